@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ovh/tat/models"
+	"github.com/ovh/tat/utils"
+)
+
+// HooksController contains all methods about outgoing webhooks of topics
+type HooksController struct{}
+
+type hookJSON struct {
+	Topic  string                  `json:"topic"`
+	URL    string                  `json:"url"`
+	Secret string                  `json:"secret"`
+	Events []string                `json:"events"`
+	Filter *models.MessageCriteria `json:"filter"`
+}
+
+// preCheckHook returns hook of url and its topic, if current user is admin on topic
+func (h *HooksController) preCheckHook(ctx *gin.Context) (models.Hook, models.Topic, error) {
+	hook := models.Hook{}
+	if err := hook.FindByID(ctx.Param("idHook")); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Webhook %s does not exist", ctx.Param("idHook"))})
+		return hook, models.Topic{}, err
+	}
+	topic, err := (&TopicsController{}).preCheckAdminOrPrivateTopic(ctx, hook.Topic)
+	return hook, topic, err
+}
+
+// List returns webhooks of a topic, given in query param topic
+// admin of topic only
+func (h *HooksController) List(ctx *gin.Context) {
+	topic, err := (&TopicsController{}).preCheckAdminOrPrivateTopic(ctx, ctx.Query("topic"))
+	if err != nil {
+		return
+	}
+	hooks, err := models.ListHooks(topic.Topic)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error while listing webhooks"})
+		return
+	}
+	if hooks == nil {
+		hooks = []models.Hook{}
+	}
+	ctx.JSON(http.StatusOK, gin.H{"hooks": hooks})
+}
+
+// Create adds a webhook on a topic. Secret is returned only on creation
+// admin of topic only
+func (h *HooksController) Create(ctx *gin.Context) {
+	var hookIn hookJSON
+	ctx.Bind(&hookIn)
+
+	topic, err := (&TopicsController{}).preCheckAdminOrPrivateTopic(ctx, hookIn.Topic)
+	if err != nil {
+		return
+	}
+
+	hook := models.Hook{URL: hookIn.URL, Secret: hookIn.Secret, Events: hookIn.Events, Filter: hookIn.Filter}
+	if err := hook.Insert(utils.GetCtxUsername(ctx), topic); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{"info": fmt.Sprintf("Webhook created on topic %s", topic.Topic), "hook": hook, "secret": hook.Secret})
+}
+
+// Update replaces url, events and filter of a webhook, and its secret if given
+// admin of topic only
+func (h *HooksController) Update(ctx *gin.Context) {
+	var hookIn hookJSON
+	ctx.Bind(&hookIn)
+
+	hook, topic, err := h.preCheckHook(ctx)
+	if err != nil {
+		return
+	}
+
+	newHook := models.Hook{URL: hookIn.URL, Secret: hookIn.Secret, Events: hookIn.Events, Filter: hookIn.Filter}
+	if err := hook.Update(utils.GetCtxUsername(ctx), topic, newHook); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{"info": fmt.Sprintf("Webhook %s updated", hook.ID), "hook": hook})
+}
+
+// Delete removes a webhook and its deliveries
+// admin of topic only
+func (h *HooksController) Delete(ctx *gin.Context) {
+	hook, topic, err := h.preCheckHook(ctx)
+	if err != nil {
+		return
+	}
+	if err := hook.Delete(utils.GetCtxUsername(ctx), topic); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error while deleting webhook"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"info": fmt.Sprintf("Webhook %s deleted", hook.ID)})
+}
+
+// Deliveries returns deliveries of a webhook, newest first, with their attempts
+// admin of topic only
+func (h *HooksController) Deliveries(ctx *gin.Context) {
+	hook, _, err := h.preCheckHook(ctx)
+	if err != nil {
+		return
+	}
+	skip, err := strconv.Atoi(ctx.DefaultQuery("skip", "0"))
+	if err != nil || skip < 0 {
+		skip = 0
+	}
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "100"))
+	if err != nil || limit < 0 {
+		limit = 100
+	}
+
+	deliveries, err := hook.ListDeliveries(skip, limit)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error while listing deliveries"})
+		return
+	}
+	if deliveries == nil {
+		deliveries = []models.HookDelivery{}
+	}
+	ctx.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
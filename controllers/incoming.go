@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/ovh/tat/models"
+	"github.com/ovh/tat/utils"
+)
+
+// maxIncomingBodySize is max size of a body posted on an incoming webhook
+const maxIncomingBodySize = 1024 * 1024
+
+// IncomingController contains all methods about incoming webhooks of topics
+type IncomingController struct{}
+
+type incomingHookJSON struct {
+	Topic     string            `json:"topic"`
+	Name      string            `json:"name"`
+	RateLimit int               `json:"rateLimit"`
+	Adapter   string            `json:"adapter"`
+	Templates map[string]string `json:"templates"`
+}
+
+// List returns incoming webhooks of a topic, given in query param topic
+// admin of topic only
+func (*IncomingController) List(ctx *gin.Context) {
+	topic, err := (&TopicsController{}).preCheckAdminOrPrivateTopic(ctx, ctx.Query("topic"))
+	if err != nil {
+		return
+	}
+	hooks, err := models.ListIncomingHooks(topic.Topic)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error while listing incoming webhooks"})
+		return
+	}
+	if hooks == nil {
+		hooks = []models.IncomingHook{}
+	}
+	ctx.JSON(http.StatusOK, gin.H{"hooks": hooks})
+}
+
+// Create adds an incoming webhook on a topic, and returns its url.
+// Url contains a token, returned only on creation
+// admin of topic only
+func (*IncomingController) Create(ctx *gin.Context) {
+	var hookIn incomingHookJSON
+	ctx.Bind(&hookIn)
+
+	topic, err := (&TopicsController{}).preCheckAdminOrPrivateTopic(ctx, hookIn.Topic)
+	if err != nil {
+		return
+	}
+
+	hook := models.IncomingHook{Name: hookIn.Name, RateLimit: hookIn.RateLimit, Adapter: hookIn.Adapter, Templates: hookIn.Templates}
+	token, err := hook.Insert(utils.GetCtxUsername(ctx), topic)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{
+		"info": fmt.Sprintf("Incoming webhook created on topic %s", topic.Topic),
+		"hook": hook,
+		"url":  utils.GetIncomingHookURL(hook.ID, token),
+	})
+}
+
+// Delete revokes an incoming webhook
+// admin of topic only
+func (*IncomingController) Delete(ctx *gin.Context) {
+	hook := models.IncomingHook{}
+	if err := hook.FindByID(ctx.Param("idHook")); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Incoming webhook %s does not exist", ctx.Param("idHook"))})
+		return
+	}
+	topic, err := (&TopicsController{}).preCheckAdminOrPrivateTopic(ctx, hook.Topic)
+	if err != nil {
+		return
+	}
+	if err := hook.Delete(utils.GetCtxUsername(ctx), topic); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error while deleting incoming webhook"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"info": fmt.Sprintf("Incoming webhook %s deleted", hook.ID)})
+}
+
+// Post creates a message on topic of an incoming webhook, without credentials.
+// Body is plain text, or JSON with text, labels, tags and idReference,
+// or a payload converted by adapter of webhook
+func (i *IncomingController) Post(ctx *gin.Context) {
+	hook := models.IncomingHook{}
+	if err := hook.FindByToken(ctx.Param("idHook"), ctx.Param("token")); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Invalid incoming webhook"})
+		return
+	}
+	if hook.Adapter != "" {
+		i.postPayload(ctx, hook)
+		return
+	}
+
+	in, err := i.readMessage(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	i.postMessage(ctx, hook, in)
+}
+
+// readMessage reads body posted on an incoming webhook, as JSON if
+// Content-Type is application/json, as plain text otherwise
+func (*IncomingController) readMessage(ctx *gin.Context) (models.IncomingMessage, error) {
+	var in models.IncomingMessage
+	body, err := ioutil.ReadAll(io.LimitReader(ctx.Request.Body, maxIncomingBodySize))
+	if err != nil {
+		return in, fmt.Errorf("Error while reading body: %s", err)
+	}
+	if strings.HasPrefix(ctx.ContentType(), "application/json") {
+		if err := json.Unmarshal(body, &in); err != nil {
+			return in, fmt.Errorf("Invalid JSON body: %s", err)
+		}
+		return in, nil
+	}
+	in.Text = string(body)
+	return in, nil
+}
+
+// postMessage inserts message on hook
+func (i *IncomingController) postMessage(ctx *gin.Context, hook models.IncomingHook, in models.IncomingMessage) {
+	message, err := hook.Post(in)
+	if err != nil {
+		i.abortPost(ctx, hook, err)
+		return
+	}
+	go i.wsEvents(models.AdapterEvents{Created: []models.Message{message}})
+	ctx.JSON(http.StatusCreated, &messageJSONOut{Message: message, Info: fmt.Sprintf("Message created in %s", hook.Topic)})
+}
+
+// postPayload converts body with adapter of hook, and creates or updates threads
+// of events. Type of event is read from headers X-GitHub-Event or X-Gitlab-Event
+func (i *IncomingController) postPayload(ctx *gin.Context, hook models.IncomingHook) {
+	body, err := ioutil.ReadAll(io.LimitReader(ctx.Request.Body, maxIncomingBodySize))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Error while reading body: %s", err)})
+		return
+	}
+	event := ctx.Request.Header.Get("X-GitHub-Event")
+	if event == "" {
+		event = ctx.Request.Header.Get("X-Gitlab-Event")
+	}
+
+	events, err := utils.ConvertPayload(hook.Adapter, event, body, hook.Templates)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(events) == 0 {
+		ctx.JSON(http.StatusOK, gin.H{"info": fmt.Sprintf("Event %s ignored by adapter %s", event, hook.Adapter)})
+		return
+	}
+
+	result, err := hook.PostEvents(events)
+	if err != nil {
+		i.abortPost(ctx, hook, err)
+		return
+	}
+	go i.wsEvents(result)
+	created := result.Created
+	if created == nil {
+		created = []models.Message{}
+	}
+	ctx.JSON(http.StatusCreated, gin.H{
+		"info":     fmt.Sprintf("%d messages created, %d messages updated in %s", len(created), len(result.Updated), hook.Topic),
+		"messages": created,
+	})
+}
+
+// abortPost writes error of a post on hook, with status 429 and header
+// Retry-After if rate limit of hook is reached
+func (*IncomingController) abortPost(ctx *gin.Context, hook models.IncomingHook, err error) {
+	if errRate, ok := err.(*models.RateLimitError); ok {
+		ctx.Header("Retry-After", strconv.FormatInt(errRate.RetryAfter, 10))
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	log.Warnf("Error while posting on incoming webhook %s: %s", hook.ID, err)
+	ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// wsEvents writes events of messages created and of messages with labels added or removed
+func (*IncomingController) wsEvents(result models.AdapterEvents) {
+	for _, message := range result.Created {
+		for _, t := range message.Topics {
+			models.WSMessageNew(&models.WSMessageNewJSON{Topic: t})
+		}
+		models.WSMessage(&models.WSMessageJSON{Action: "create", Username: message.Author.Username, Message: message})
+	}
+	for _, message := range result.Unlabeled {
+		models.WSMessage(&models.WSMessageJSON{Action: "unlabel", Username: message.Author.Username, Message: message})
+	}
+	for _, message := range result.Labeled {
+		models.WSMessage(&models.WSMessageJSON{Action: "label", Username: message.Author.Username, Message: message})
+	}
+}
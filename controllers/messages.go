@@ -17,8 +17,15 @@ import (
 type MessagesController struct{}
 
 type messagesJSON struct {
-	Messages  []models.Message `json:"messages"`
-	IsTopicRw bool             `json:"isTopicRw"`
+	Messages  []models.Message       `json:"messages"`
+	IsTopicRw bool                   `json:"isTopicRw"`
+	Cursor    *models.MessagesCursor `json:"cursor,omitempty"`
+	Pinned    []models.Message       `json:"pinned,omitempty"`
+}
+
+type boardJSON struct {
+	Topic   string               `json:"topic"`
+	Columns []models.BoardColumn `json:"columns"`
 }
 
 type messageJSONOut struct {
@@ -26,15 +33,30 @@ type messageJSONOut struct {
 	Info    string         `json:"info"`
 }
 
+type messageBulkResultJSON struct {
+	Status  int             `json:"status"`
+	Error   string          `json:"error,omitempty"`
+	Message *models.Message `json:"message,omitempty"`
+}
+
+type messagesBulkJSONOut struct {
+	Results []messageBulkResultJSON `json:"results"`
+}
+
 type messageJSON struct {
 	ID           string `json:"_id"`
 	Text         string `json:"text"`
 	Option       string `json:"option"`
 	Topic        string
-	IDReference  string         `json:"idReference"`
-	Action       string         `json:"action"`
-	DateCreation int64          `json:"dateCreation"`
-	Labels       []models.Label `json:"labels"`
+	IDReference  string            `json:"idReference"`
+	Action       string            `json:"action"`
+	DateCreation int64             `json:"dateCreation"`
+	DatePublish  int64             `json:"datePublish"`
+	ExternalKey  string            `json:"externalKey"`
+	Upsert       bool              `json:"upsert"`
+	Attributes   models.Attributes `json:"attributes"`
+	Position     *int              `json:"position"`
+	Labels       []models.Label    `json:"labels"`
 }
 
 func (*MessagesController) buildCriteria(ctx *gin.Context) *models.MessageCriteria {
@@ -56,12 +78,17 @@ func (*MessagesController) buildCriteria(ctx *gin.Context) *models.MessageCriter
 	c.InReplyOfIDRoot = ctx.Query("inReplyOfIDRoot")
 	c.AllIDMessage = ctx.Query("allIDMessage")
 	c.Text = ctx.Query("text")
+	c.Search = ctx.Query("search")
+	c.SortBy = ctx.Query("sort")
+	c.After = ctx.Query("after")
+	c.Before = ctx.Query("before")
 	c.Label = ctx.Query("label")
 	c.NotLabel = ctx.Query("notLabel")
 	c.AndLabel = ctx.Query("andLabel")
 	c.Tag = ctx.Query("tag")
 	c.NotTag = ctx.Query("notTag")
 	c.AndTag = ctx.Query("andTag")
+	c.Reaction = ctx.Query("reaction")
 	c.DateMinCreation = ctx.Query("dateMinCreation")
 	c.DateMaxCreation = ctx.Query("dateMaxCreation")
 	c.DateMinUpdate = ctx.Query("dateMinUpdate")
@@ -70,6 +97,13 @@ func (*MessagesController) buildCriteria(ctx *gin.Context) *models.MessageCriter
 	c.LimitMinNbReplies = ctx.Query("limitMinNbReplies")
 	c.LimitMaxNbReplies = ctx.Query("limitMaxNbReplies")
 	c.OnlyMsgRoot = ctx.Query("onlyMsgRoot")
+	for name, values := range ctx.Request.URL.Query() {
+		for _, value := range values {
+			if key, operator, val, ok := utils.ParseAttributeFilter(name, value); ok {
+				c.Attributes = append(c.Attributes, models.AttributeFilter{Key: key, Operator: operator, Value: val})
+			}
+		}
+	}
 	return &c
 }
 
@@ -81,8 +115,103 @@ func (m *MessagesController) List(ctx *gin.Context) {
 	if err != nil {
 		return
 	}
+
+	if criteria.SortBy != "" && criteria.SortBy != models.SortByRelevance && !models.IsSortByAttribute(criteria.SortBy) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort " + criteria.SortBy})
+		return
+	}
+	if models.IsSortByAttribute(criteria.SortBy) && (criteria.TreeView != "" || criteria.After != "" || criteria.Before != "") {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "sort " + criteria.SortBy + " can't be used with a treeView or a cursor"})
+		return
+	}
+	if err := m.checkAttributeFilters(ctx, criteria); err != nil {
+		return
+	}
+	if criteria.SortBy == models.SortByRelevance && criteria.Search == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "sort=relevance needs a search"})
+		return
+	}
+	if criteria.SortBy == models.SortByRelevance && criteria.TreeView != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "sort=relevance can't be used with a treeView"})
+		return
+	}
+	if criteria.After != "" && criteria.Before != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "after and before can't be used together"})
+		return
+	}
+	for _, cursor := range []string{criteria.After, criteria.Before} {
+		if cursor == "" {
+			continue
+		}
+		if criteria.SortBy == models.SortByRelevance {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "sort=relevance can't be used with a cursor"})
+			return
+		}
+		if _, _, err := utils.DecodeCursor(cursor); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 	criteria.Topic = topicIn
 
+	topic, user, err := m.preCheckTopicRead(ctx, criteria)
+	if err != nil {
+		return
+	}
+	out := &messagesJSON{}
+	if utils.GetCtxUsername(ctx) != "" {
+		out.IsTopicRw = topic.IsUserRW(&user)
+	}
+
+	// send presence
+	if presenceArg != "" && !user.IsSystem {
+		go func() {
+			var presence = models.Presence{}
+			err := presence.Upsert(user, topic, presenceArg)
+			if err != nil {
+				log.Errorf("Error while InsertPresence %s", err)
+			}
+			go models.WSPresence(&models.WSPresenceJSON{Action: "create", Presence: presence})
+		}()
+
+	}
+
+	messages, cursor, err := models.ListMessagesPage(criteria)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	out.Messages = messages
+	if cursor.Before != "" || cursor.After != "" {
+		out.Cursor = &cursor
+	}
+
+	// pinned messages are on first page only
+	if criteria.Skip == 0 && criteria.After == "" && criteria.Before == "" {
+		out.Pinned, err = topic.PinnedMessages()
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	ctx.JSON(http.StatusOK, out)
+}
+
+func (m *MessagesController) checkAttributeFilters(ctx *gin.Context, criteria *models.MessageCriteria) error {
+	for _, filter := range criteria.Attributes {
+		if !models.IsValidAttributeFilter(filter) {
+			e := errors.New("Invalid filter on attribute " + filter.Key)
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+			return e
+		}
+	}
+	return nil
+}
+
+// preCheckTopicRead finds topic of criteria, creating a DM topic if needed,
+// and checks read access of user, or public read access without user
+func (m *MessagesController) preCheckTopicRead(ctx *gin.Context, criteria *models.MessageCriteria) (models.Topic, models.User, error) {
 	// add / if search on topic
 	// as topic is in path, it can't start with a /
 	if criteria.Topic != "" && string(criteria.Topic[0]) != "/" {
@@ -90,67 +219,86 @@ func (m *MessagesController) List(ctx *gin.Context) {
 	}
 
 	var topic = models.Topic{}
-	err = topic.FindByTopic(criteria.Topic, true)
+	var user models.User
+	err := topic.FindByTopic(criteria.Topic, true)
 	if err != nil {
 		topicCriteria := ""
 		_, topicCriteria, err = m.checkDMTopic(ctx, criteria.Topic)
 		if err != nil {
 			ctx.JSON(http.StatusBadRequest, gin.H{"error": "topic " + criteria.Topic + " does not exist"})
-			return
+			return topic, user, err
 		}
 		// hack to get new created DM Topic
 		err := topic.FindByTopic(criteria.Topic, true)
 		if err != nil {
 			ctx.JSON(http.StatusBadRequest, gin.H{"error": "topic " + criteria.Topic + " does not exist (2)"})
-			return
+			return topic, user, err
 		}
 		criteria.Topic = topicCriteria
 	}
 
-	out := &messagesJSON{}
-
-	var user models.User
-	var e error
 	if utils.GetCtxUsername(ctx) != "" {
-		user, e = PreCheckUser(ctx)
-		if e != nil {
-			return
+		user, err = PreCheckUser(ctx)
+		if err != nil {
+			return topic, user, err
 		}
 		isReadAccess := topic.IsUserReadAccess(user)
 		if !isReadAccess {
-			ctx.JSON(http.StatusForbidden, gin.H{"error": "No Read Access to this topic"})
-			return
+			e := errors.New("No Read Access to this topic")
+			ctx.JSON(http.StatusForbidden, gin.H{"error": e.Error()})
+			return topic, user, e
 		}
-		out.IsTopicRw = topic.IsUserRW(&user)
 	} else if !topic.IsROPublic {
-		ctx.JSON(http.StatusForbidden, gin.H{"error": "No Public Read Access Public to this topic"})
-		return
+		e := errors.New("No Public Read Access Public to this topic")
+		ctx.JSON(http.StatusForbidden, gin.H{"error": e.Error()})
+		return topic, user, e
 	} else if topic.IsROPublic && strings.HasPrefix(topic.Topic, "/Private") {
-		ctx.JSON(http.StatusForbidden, gin.H{"error": "No Public Read Access to this topic"})
+		e := errors.New("No Public Read Access to this topic")
+		ctx.JSON(http.StatusForbidden, gin.H{"error": e.Error()})
+		return topic, user, e
+	}
+	return topic, user, nil
+}
+
+// Board returns root messages of a topic by columns, a column is a label.
+// Each column contains count of messages with its label, and limit newest of them
+func (m *MessagesController) Board(ctx *gin.Context) {
+	var criteria = m.buildCriteria(ctx)
+	topicIn, err := GetParam(ctx, "topic")
+	if err != nil {
 		return
 	}
 
-	// send presence
-	if presenceArg != "" && !user.IsSystem {
-		go func() {
-			var presence = models.Presence{}
-			err := presence.Upsert(user, topic, presenceArg)
-			if err != nil {
-				log.Errorf("Error while InsertPresence %s", err)
-			}
-			go models.WSPresence(&models.WSPresenceJSON{Action: "create", Presence: presence})
-		}()
+	var columns []string
+	for _, c := range strings.Split(ctx.Query("columns"), ",") {
+		if c = strings.TrimSpace(c); c != "" && !utils.ArrayContains(columns, c) {
+			columns = append(columns, c)
+		}
+	}
+	if len(columns) == 0 || len(columns) > models.MaxBoardColumns {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid columns, 1 to %d labels are expected", models.MaxBoardColumns)})
+		return
+	}
+	if criteria.TreeView != "" || criteria.SortBy != "" || criteria.After != "" || criteria.Before != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "treeView, sort and cursors can't be used on a board"})
+		return
+	}
+	if err := m.checkAttributeFilters(ctx, criteria); err != nil {
+		return
+	}
+	criteria.Topic = topicIn
 
+	topic, _, err := m.preCheckTopicRead(ctx, criteria)
+	if err != nil {
+		return
 	}
 
-	messages, err := models.ListMessages(criteria)
+	board, err := models.ListBoard(criteria, columns)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-
-	out.Messages = messages
-	ctx.JSON(http.StatusOK, out)
+	ctx.JSON(http.StatusOK, &boardJSON{Topic: topic.Topic, Columns: board})
 }
 
 func (m *MessagesController) preCheckTopic(ctx *gin.Context) (messageJSON, models.Message, models.Topic, error) {
@@ -184,13 +332,16 @@ func (m *MessagesController) preCheckTopic(ctx *gin.Context) (messageJSON, model
 		}
 
 		topicName := ""
-		if messageIn.Action == "update" {
+		if messageIn.Action == "update" || messageIn.Action == "pin" || messageIn.Action == "unpin" ||
+			messageIn.Action == "share" || messageIn.Action == "unshare" {
 			topicName = messageIn.Topic
 		} else if messageIn.Action == "reply" || messageIn.Action == "unbookmark" ||
 			messageIn.Action == "like" || messageIn.Action == "unlike" ||
+			messageIn.Action == "react" || messageIn.Action == "unreact" ||
+			messageIn.Action == "attributes" ||
 			messageIn.Action == "label" || messageIn.Action == "unlabel" ||
 			messageIn.Action == "tag" || messageIn.Action == "untag" {
-			topicName = m.inverseIfDMTopic(ctx, message.Topics[0])
+			topicName = m.topicOfMessage(ctx, messageIn.Topic, message)
 		} else if messageIn.Action == "move" {
 			topicName = topicIn
 		} else if messageIn.Action == "task" || messageIn.Action == "untask" {
@@ -244,6 +395,16 @@ func (m *MessagesController) Create(ctx *gin.Context) {
 		return
 	}
 
+	if err := models.TakeMessageTokens(user, topic, 1); err != nil {
+		m.abortRateLimit(ctx, err)
+		return
+	}
+
+	if messageIn.DatePublish > 0 {
+		m.createScheduled(ctx, &messageIn, user, topic)
+		return
+	}
+
 	var message = models.Message{}
 
 	info := ""
@@ -262,13 +423,38 @@ func (m *MessagesController) Create(ctx *gin.Context) {
 		}
 		info = fmt.Sprintf("New Bookmark created in %s", topic.Topic)
 	} else {
+		message.ExternalKey = messageIn.ExternalKey
+		message.Attributes = messageIn.Attributes
 		err := message.Insert(user, topic, messageIn.Text, messageIn.IDReference, messageIn.DateCreation, messageIn.Labels, false)
-		if err != nil {
+		if errDup, ok := err.(*models.DuplicateExternalKeyError); ok {
+			if !messageIn.Upsert {
+				ctx.JSON(http.StatusConflict, gin.H{"error": err.Error(), "message": errDup.Message})
+				return
+			}
+			updated, code, err := m.upsertDuplicate(&messageIn, errDup, user, topic)
+			if err != nil {
+				ctx.JSON(code, gin.H{"error": err.Error()})
+				return
+			}
+			ctx.JSON(code, &messageJSONOut{Message: updated, Info: fmt.Sprintf("Message updated in %s", errDup.Topic)})
+			return
+		} else if errValidation, ok := err.(*models.ValidationError); ok {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "rule": errValidation.Rule})
+			return
+		} else if _, ok := err.(*models.RateLimitError); ok {
+			// rate limit of a topic of a rule moveTo
+			m.abortRateLimit(ctx, err)
+			return
+		} else if err != nil {
 			log.Errorf("%s", err.Error())
 			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		go models.WSMessageNew(&models.WSMessageNewJSON{Topic: topic.Topic})
+		go func() {
+			for _, t := range message.Topics {
+				models.WSMessageNew(&models.WSMessageNewJSON{Topic: t})
+			}
+		}()
 		info = fmt.Sprintf("Message created in %s", topic.Topic)
 	}
 	out := &messageJSONOut{Message: message, Info: info}
@@ -276,6 +462,230 @@ func (m *MessagesController) Create(ctx *gin.Context) {
 	ctx.JSON(http.StatusCreated, out)
 }
 
+// bulkTopic is a topic checked once for all messages of a bulk
+type bulkTopic struct {
+	topic  models.Topic
+	status int
+	err    string
+}
+
+// CreateBulk creates many messages, on many topics, with replies.
+// Result of each message is returned, in same order
+func (m *MessagesController) CreateBulk(ctx *gin.Context) {
+	var messagesIn []messageJSON
+	if err := ctx.BindJSON(&messagesIn); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid body, an array of messages is expected"})
+		return
+	}
+	if len(messagesIn) == 0 || len(messagesIn) > models.MaxBulkMessages {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid number of messages %d, max is %d", len(messagesIn), models.MaxBulkMessages)})
+		return
+	}
+
+	user, e := PreCheckUser(ctx)
+	if e != nil {
+		return
+	}
+
+	topics := make(map[string]*bulkTopic)
+	externalKeys := make(map[string]bool)
+	results := make([]messageBulkResultJSON, len(messagesIn))
+	messages := make([]*models.BulkMessage, len(messagesIn))
+
+	for i, messageIn := range messagesIn {
+		messages[i] = &models.BulkMessage{
+			Text:         messageIn.Text,
+			InReplyOfID:  messageIn.IDReference,
+			DateCreation: messageIn.DateCreation,
+			Labels:       messageIn.Labels,
+			Attributes:   messageIn.Attributes,
+		}
+
+		if messageIn.Action != "" && messageIn.Action != "reply" {
+			results[i] = messageBulkResultJSON{Status: http.StatusBadRequest, Error: "Invalid action " + messageIn.Action + " in bulk"}
+			continue
+		}
+		if messageIn.DatePublish > 0 {
+			results[i] = messageBulkResultJSON{Status: http.StatusBadRequest, Error: "datePublish can't be used in bulk"}
+			continue
+		}
+
+		topicName := messageIn.Topic
+		if messageIn.IDReference != "" {
+			var reference = models.Message{}
+			if err := reference.FindByID(messageIn.IDReference); err != nil {
+				results[i] = messageBulkResultJSON{Status: http.StatusNotFound, Error: "Message " + messageIn.IDReference + " does not exist"}
+				continue
+			}
+			messages[i].Reference = &reference
+			topicName = m.topicOfMessage(ctx, messageIn.Topic, reference)
+		}
+
+		t, ok := topics[topicName]
+		if !ok {
+			t = m.checkBulkTopic(ctx, topicName, user)
+			topics[topicName] = t
+		}
+		if t.err != "" {
+			results[i] = messageBulkResultJSON{Status: t.status, Error: t.err}
+			continue
+		}
+		messages[i].Topic = t.topic
+
+		if messageIn.ExternalKey == "" {
+			continue
+		}
+		key := t.topic.Topic + " " + messageIn.ExternalKey
+		if externalKeys[key] {
+			results[i] = messageBulkResultJSON{Status: http.StatusConflict, Error: "External key " + messageIn.ExternalKey + " is used twice in bulk"}
+			continue
+		}
+		externalKeys[key] = true
+		messages[i].ExternalKey = messageIn.ExternalKey
+
+		var existing = models.Message{}
+		if messageIn.Upsert && existing.FindByExternalKey(t.topic.Topic, messageIn.ExternalKey) == nil {
+			updated, code, err := m.upsertMessage(&messagesIn[i], existing, user, t.topic)
+			if err != nil {
+				results[i] = messageBulkResultJSON{Status: code, Error: err.Error()}
+			} else {
+				results[i] = messageBulkResultJSON{Status: code, Message: &updated}
+			}
+		}
+	}
+
+	m.takeBulkTokens(ctx, user, messages, results)
+
+	// messages with a result, rejected or updated by external key, are not inserted
+	for i := range messages {
+		if results[i].Status != 0 {
+			messages[i].Err = errors.New(results[i].Error)
+		}
+	}
+
+	models.InsertBulk(user, messages)
+
+	status := http.StatusCreated
+	var created []models.Message
+	topicsCreated := make(map[string]bool)
+	for i, msg := range messages {
+		if errDup, ok := msg.Err.(*models.DuplicateExternalKeyError); ok && messagesIn[i].Upsert {
+			// message moved by a rule to a sub-topic, or inserted meanwhile
+			if updated, code, err := m.upsertDuplicate(&messagesIn[i], errDup, user, msg.Topic); err != nil {
+				results[i] = messageBulkResultJSON{Status: code, Error: err.Error()}
+			} else {
+				results[i] = messageBulkResultJSON{Status: code, Message: &updated}
+			}
+		} else if _, ok := msg.Err.(*models.DuplicateExternalKeyError); ok {
+			results[i] = messageBulkResultJSON{Status: http.StatusConflict, Error: msg.Err.Error()}
+		} else if _, ok := msg.Err.(*models.RateLimitError); ok && results[i].Status == 0 {
+			results[i] = messageBulkResultJSON{Status: http.StatusTooManyRequests, Error: msg.Err.Error()}
+		} else if results[i].Status == 0 && msg.Err != nil {
+			results[i] = messageBulkResultJSON{Status: http.StatusBadRequest, Error: msg.Err.Error()}
+		}
+		if results[i].Status == http.StatusOK {
+			// updated by external key
+			continue
+		} else if results[i].Status != 0 {
+			status = http.StatusMultiStatus
+			continue
+		}
+		results[i] = messageBulkResultJSON{Status: http.StatusCreated, Message: &messages[i].Message}
+		created = append(created, msg.Message)
+		// a message moved by a rule of topic is on another topic
+		for _, t := range msg.Message.Topics {
+			topicsCreated[t] = true
+		}
+	}
+
+	go func() {
+		for topicName := range topicsCreated {
+			models.WSMessageNew(&models.WSMessageNewJSON{Topic: topicName})
+		}
+		for i := range created {
+			models.WSMessage(&models.WSMessageJSON{Action: "create", Username: user.Username, Message: created[i]})
+		}
+	}()
+	ctx.JSON(status, &messagesBulkJSONOut{Results: results})
+}
+
+// takeBulkTokens checks rate limits once by topic, for all messages of bulk to insert
+// on it. Messages of a topic over its rate limit are rejected with status 429, others
+// are inserted
+func (m *MessagesController) takeBulkTokens(ctx *gin.Context, user models.User, messages []*models.BulkMessage, results []messageBulkResultJSON) {
+	counts := make(map[string]int)
+	topics := make(map[string]models.Topic)
+	for i, msg := range messages {
+		if results[i].Status == 0 {
+			counts[msg.Topic.Topic]++
+			topics[msg.Topic.Topic] = msg.Topic
+		}
+	}
+
+	for topicName, n := range counts {
+		allowed, err := models.TakeMessageTokensUpTo(user, topics[topicName], n)
+		errRate, ok := err.(*models.RateLimitError)
+		if !ok {
+			continue
+		}
+		ctx.Header("Retry-After", strconv.FormatInt(errRate.RetryAfter, 10))
+		for i, msg := range messages {
+			if results[i].Status == 0 && msg.Topic.Topic == topicName {
+				if allowed > 0 {
+					allowed--
+					continue
+				}
+				results[i] = messageBulkResultJSON{Status: http.StatusTooManyRequests, Error: err.Error()}
+			}
+		}
+	}
+}
+
+// abortRateLimit writes status 429 with header Retry-After if err is a RateLimitError
+func (m *MessagesController) abortRateLimit(ctx *gin.Context, err error) {
+	if errRate, ok := err.(*models.RateLimitError); ok {
+		ctx.Header("Retry-After", strconv.FormatInt(errRate.RetryAfter, 10))
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error(), "scope": errRate.Scope})
+		return
+	}
+	ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+func (m *MessagesController) checkBulkTopic(ctx *gin.Context, topicName string, user models.User) *bulkTopic {
+	var topic = models.Topic{}
+	if err := topic.FindByTopic(topicName, true); err != nil {
+		topic, _, err = m.checkDMTopic(ctx, topicName)
+		if err != nil {
+			return &bulkTopic{status: http.StatusNotFound, err: "Topic " + topicName + " does not exist"}
+		}
+	}
+	if !topic.IsUserRW(&user) {
+		return &bulkTopic{status: http.StatusForbidden, err: "No RW Access to topic " + topic.Topic}
+	}
+	return &bulkTopic{topic: topic}
+}
+
+func (m *MessagesController) createScheduled(ctx *gin.Context, messageIn *messageJSON, user models.User, topic models.Topic) {
+	if messageIn.Action != "" && messageIn.Action != "reply" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "datePublish can't be used with action " + messageIn.Action})
+		return
+	}
+
+	var scheduled = models.ScheduledMessage{}
+	err := scheduled.Insert(user, topic, messageIn.Text, messageIn.IDReference, messageIn.DatePublish, messageIn.Labels)
+	if errValidation, ok := err.(*models.ValidationError); ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "rule": errValidation.Rule})
+		return
+	} else if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{
+		"info":             fmt.Sprintf("Message scheduled in %s", topic.Topic),
+		"scheduledMessage": scheduled,
+	})
+}
+
 // Update a message : like, unlike, add label, etc...
 func (m *MessagesController) Update(ctx *gin.Context) {
 	messageIn, messageReference, topic, e := m.preCheckTopic(ctx)
@@ -293,6 +703,16 @@ func (m *MessagesController) Update(ctx *gin.Context) {
 		return
 	}
 
+	if messageIn.Action == "react" || messageIn.Action == "unreact" {
+		m.reactOrUnreact(ctx, &messageIn, messageReference, topic, user)
+		return
+	}
+
+	if messageIn.Action == "pin" || messageIn.Action == "unpin" {
+		m.pinOrUnpin(ctx, &messageIn, messageReference, topic, user)
+		return
+	}
+
 	isRw := topic.IsUserRW(&user)
 	if !isRw {
 		ctx.AbortWithError(http.StatusForbidden, errors.New("No RW Access to topic : "+messageIn.Topic))
@@ -300,7 +720,12 @@ func (m *MessagesController) Update(ctx *gin.Context) {
 	}
 
 	if messageIn.Action == "label" || messageIn.Action == "unlabel" {
-		m.addOrRemoveLabel(ctx, &messageIn, messageReference, user)
+		m.addOrRemoveLabel(ctx, &messageIn, messageReference, user, topic)
+		return
+	}
+
+	if messageIn.Action == "attributes" {
+		m.setAttributes(ctx, &messageIn, messageReference, user)
 		return
 	}
 
@@ -324,6 +749,11 @@ func (m *MessagesController) Update(ctx *gin.Context) {
 		return
 	}
 
+	if messageIn.Action == "share" || messageIn.Action == "unshare" {
+		m.shareOrUnshare(ctx, &messageIn, messageReference, user, topic)
+		return
+	}
+
 	ctx.JSON(http.StatusBadRequest, gin.H{"error": "Action invalid."})
 }
 
@@ -361,12 +791,7 @@ func (m *MessagesController) messageDelete(ctx *gin.Context, cascade bool) {
 		return
 	}
 
-	c := &models.MessageCriteria{
-		InReplyOfID: message.ID,
-		TreeView:    "onetree",
-	}
-
-	msgs, err := models.ListMessages(c)
+	replies, err := message.AllReplies()
 	if err != nil {
 		log.Errorf("Error while list Messages in Delete %s", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error while list Messages in Delete"})
@@ -374,14 +799,14 @@ func (m *MessagesController) messageDelete(ctx *gin.Context, cascade bool) {
 	}
 
 	if cascade {
-		for _, r := range msgs {
+		for _, r := range replies {
 			_, err := m.checkBeforeDelete(ctx, r, user)
 			if err != nil {
 				// ctx writes in checkBeforeDelete
 				return
 			}
 		}
-	} else if len(msgs) > 0 {
+	} else if len(replies) > 0 {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Could not delete this message, this message have replies"})
 		return
 	}
@@ -397,7 +822,7 @@ func (m *MessagesController) messageDelete(ctx *gin.Context, cascade bool) {
 	ctx.JSON(http.StatusOK, gin.H{"info": fmt.Sprintf("Message deleted from %s", topic.Topic)})
 }
 
-// checkBeforeDelete checks
+// checkBeforeDelete checks, on original topic of message, its first topic
 // - if user is RW on topic
 // - if topic is Private OR is CanDeleteMsg or CanDeleteAllMsg
 func (m *MessagesController) checkBeforeDelete(ctx *gin.Context, message models.Message, user models.User) (models.Topic, error) {
@@ -482,20 +907,90 @@ func (m *MessagesController) likeOrUnlike(ctx *gin.Context, action string, messa
 	ctx.JSON(http.StatusCreated, gin.H{"info": info})
 }
 
-func (m *MessagesController) addOrRemoveLabel(ctx *gin.Context, messageIn *messageJSON, message models.Message, user models.User) {
+func (m *MessagesController) reactOrUnreact(ctx *gin.Context, messageIn *messageJSON, message models.Message, topic models.Topic, user models.User) {
+	isReadAccess := topic.IsUserReadAccess(user)
+	if !isReadAccess {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "No Read Access to topic " + message.Topics[0]})
+		return
+	}
+
+	if err := models.CheckReaction(messageIn.Text); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	info := gin.H{}
+	if messageIn.Action == "react" {
+		err := message.React(user, messageIn.Text)
+		if err != nil {
+			log.Errorf("Error while adding a reaction to a message %s", err)
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		info = gin.H{"info": fmt.Sprintf("reaction %s added to message", messageIn.Text), "message": message}
+	} else if messageIn.Action == "unreact" {
+		err := message.Unreact(user, messageIn.Text)
+		if err != nil {
+			log.Errorf("Error while removing a reaction from a message %s", err)
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		info = gin.H{"info": fmt.Sprintf("reaction %s removed from message", messageIn.Text), "message": message}
+	} else {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("Invalid action : "+messageIn.Action))
+		return
+	}
+	go models.WSMessage(&models.WSMessageJSON{Action: messageIn.Action, Username: user.Username, Reaction: messageIn.Text, Message: message})
+	ctx.JSON(http.StatusCreated, info)
+}
+
+func (m *MessagesController) pinOrUnpin(ctx *gin.Context, messageIn *messageJSON, message models.Message, topic models.Topic, user models.User) {
+	if !topic.IsUserAdmin(&user) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("No Admin Access to topic %s", topic.Topic)})
+		return
+	}
+
+	var err error
+	var info string
+	if messageIn.Action == "pin" {
+		position := -1
+		if messageIn.Position != nil {
+			position = *messageIn.Position
+		}
+		err = topic.PinMessage(user.Username, message, position)
+		info = fmt.Sprintf("message pinned on topic %s", topic.Topic)
+	} else {
+		err = topic.UnpinMessage(user.Username, message.ID)
+		info = fmt.Sprintf("message unpinned from topic %s", topic.Topic)
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	go models.WSPin(&models.WSPinJSON{Action: messageIn.Action, Username: user.Username, Topic: topic.Topic, Pinned: topic.Pinned, Message: message})
+	ctx.JSON(http.StatusCreated, gin.H{"info": info, "pinned": topic.Pinned})
+}
+
+func (m *MessagesController) addOrRemoveLabel(ctx *gin.Context, messageIn *messageJSON, message models.Message, user models.User, topic models.Topic) {
 	if messageIn.Text == "" {
 		ctx.AbortWithError(http.StatusBadRequest, errors.New("Invalid Text for label"))
 		return
 	}
 	info := gin.H{}
+	var removedLabels []models.Label
 	if messageIn.Action == "label" {
-		addedLabel, err := message.AddLabel(messageIn.Text, messageIn.Option)
+		var addedLabel models.Label
+		var err error
+		addedLabel, removedLabels, err = message.AddLabelOnTopic(topic, messageIn.Text, messageIn.Option)
 		if err != nil {
 			log.Errorf("Error while adding a label to a message %s", err)
-			ctx.AbortWithError(http.StatusInternalServerError, err)
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 		info = gin.H{"info": fmt.Sprintf("label %s added to message", addedLabel.Text), "label": addedLabel, "message": message}
+		if len(removedLabels) > 0 {
+			info["removedLabels"] = removedLabels
+		}
 	} else if messageIn.Action == "unlabel" {
 		err := message.RemoveLabel(messageIn.Text)
 		if err != nil {
@@ -508,10 +1003,29 @@ func (m *MessagesController) addOrRemoveLabel(ctx *gin.Context, messageIn *messa
 		ctx.AbortWithError(http.StatusBadRequest, errors.New("Invalid action : "+messageIn.Action))
 		return
 	}
-	go models.WSMessage(&models.WSMessageJSON{Action: messageIn.Action, Username: user.Username, Message: message})
+	go func() {
+		// labels of an exclusive group removed by label
+		for range removedLabels {
+			models.WSMessage(&models.WSMessageJSON{Action: "unlabel", Username: user.Username, Message: message})
+		}
+		models.WSMessage(&models.WSMessageJSON{Action: messageIn.Action, Username: user.Username, Message: message})
+	}()
 	ctx.JSON(http.StatusCreated, info)
 }
 
+func (m *MessagesController) setAttributes(ctx *gin.Context, messageIn *messageJSON, message models.Message, user models.User) {
+	if len(messageIn.Attributes) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid attributes, at least one attribute is expected"})
+		return
+	}
+	if err := message.SetAttributes(messageIn.Attributes); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	go models.WSMessage(&models.WSMessageJSON{Action: messageIn.Action, Username: user.Username, Message: message})
+	ctx.JSON(http.StatusCreated, gin.H{"info": "attributes updated on message", "message": message})
+}
+
 func (m *MessagesController) addOrRemoveTag(ctx *gin.Context, messageIn *messageJSON, message models.Message, user models.User) {
 
 	if !user.IsSystem {
@@ -581,19 +1095,16 @@ func (m *MessagesController) updateMessage(ctx *gin.Context, messageIn *messageJ
 	info := ""
 	if messageIn.Action == "update" {
 
-		if !topic.CanUpdateMsg && !topic.CanUpdateAllMsg {
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("You can't update a message on topic %s", topic.Topic)})
+		if err := m.checkBeforeUpdate(message, user, topic); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		if !topic.CanUpdateAllMsg && message.Author.Username != user.Username {
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Could not update a message from another user %s than you %s", message.Author.Username, user.Username)})
+		err := message.Update(user, topic, messageIn.Text)
+		if errValidation, ok := err.(*models.ValidationError); ok {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "rule": errValidation.Rule})
 			return
-		}
-
-		message.Text = messageIn.Text
-		err := message.Update(user, topic)
-		if err != nil {
+		} else if err != nil {
 			log.Errorf("Error while update a message %s", err)
 			ctx.AbortWithError(http.StatusInternalServerError, err)
 			return
@@ -608,6 +1119,199 @@ func (m *MessagesController) updateMessage(ctx *gin.Context, messageIn *messageJ
 	ctx.JSON(http.StatusOK, out)
 }
 
+func (m *MessagesController) checkBeforeUpdate(message models.Message, user models.User, topic models.Topic) error {
+	if !topic.CanUpdateMsg && !topic.CanUpdateAllMsg {
+		return fmt.Errorf("You can't update a message on topic %s", topic.Topic)
+	}
+
+	if !topic.CanUpdateAllMsg && message.Author.Username != user.Username {
+		return fmt.Errorf("Could not update a message from another user %s than you %s", message.Author.Username, user.Username)
+	}
+	return nil
+}
+
+// upsertDuplicate updates message with same external key as messageIn, on topic
+// or on a sub-topic where a rule of topic moved it
+func (m *MessagesController) upsertDuplicate(messageIn *messageJSON, errDup *models.DuplicateExternalKeyError, user models.User, topic models.Topic) (models.Message, int, error) {
+	if errDup.Topic != topic.Topic {
+		topic = models.Topic{}
+		if err := topic.FindByTopic(errDup.Topic, true); err != nil {
+			return errDup.Message, http.StatusInternalServerError, err
+		}
+	}
+	return m.upsertMessage(messageIn, errDup.Message, user, topic)
+}
+
+// upsertMessage updates text and labels of message with same external key as messageIn.
+// Labels of message are replaced by labels of messageIn, if not nil
+func (m *MessagesController) upsertMessage(messageIn *messageJSON, message models.Message, user models.User, topic models.Topic) (models.Message, int, error) {
+	var events []string
+	if messageIn.Text != "" && messageIn.Text != message.Text {
+		if err := m.checkBeforeUpdate(message, user, topic); err != nil {
+			return message, http.StatusBadRequest, err
+		}
+		if err := message.Update(user, topic, messageIn.Text); err != nil {
+			if _, ok := err.(*models.ValidationError); ok {
+				return message, http.StatusBadRequest, err
+			}
+			log.Errorf("Error while update a message %s", err)
+			return message, http.StatusInternalServerError, err
+		}
+		events = append(events, "update")
+	}
+
+	if messageIn.Labels != nil {
+		labels, err := topic.CheckCatalogLabels(messageIn.Labels)
+		if err != nil {
+			return message, http.StatusBadRequest, err
+		}
+		// RemoveLabel updates message.Labels, labels to remove are listed before
+		var toRemove []models.Label
+		for _, l := range message.Labels {
+			if !containsLabel(labels, l) {
+				toRemove = append(toRemove, l)
+			}
+		}
+		for _, l := range toRemove {
+			if err := message.RemoveLabel(l.Text); err != nil {
+				log.Errorf("Error while remove a label from a message %s", err)
+				return message, http.StatusInternalServerError, err
+			}
+			events = append(events, "unlabel")
+		}
+		for _, l := range labels {
+			if !containsLabel(message.Labels, l) {
+				if _, err := message.AddLabel(l.Text, l.Color); err != nil {
+					log.Errorf("Error while adding a label to a message %s", err)
+					return message, http.StatusInternalServerError, err
+				}
+				events = append(events, "label")
+			}
+		}
+	}
+
+	if len(messageIn.Attributes) > 0 {
+		if err := message.SetAttributes(messageIn.Attributes); err != nil {
+			return message, http.StatusBadRequest, err
+		}
+		events = append(events, "attributes")
+	}
+
+	go func() {
+		for _, action := range events {
+			models.WSMessage(&models.WSMessageJSON{Action: action, Username: user.Username, Message: message})
+		}
+	}()
+	return message, http.StatusOK, nil
+}
+
+func containsLabel(labels []models.Label, label models.Label) bool {
+	for _, l := range labels {
+		if l.Text == label.Text && l.Color == label.Color {
+			return true
+		}
+	}
+	return false
+}
+
+// Revisions returns previous texts of a message
+func (m *MessagesController) Revisions(ctx *gin.Context) {
+	message, e := m.preCheckReadMessage(ctx)
+	if e != nil {
+		return
+	}
+
+	revisions, err := models.ListRevisions(message.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error while getting revisions"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": message, "nbRevisions": message.NbRevisions, "revisions": revisions})
+}
+
+// DiffRevisions returns differences between two revisions of a message.
+// Revision number nbRevisions is the current text of message
+func (m *MessagesController) DiffRevisions(ctx *gin.Context) {
+	message, e := m.preCheckReadMessage(ctx)
+	if e != nil {
+		return
+	}
+
+	to, err := strconv.Atoi(ctx.DefaultQuery("to", strconv.Itoa(message.NbRevisions)))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision to"})
+		return
+	}
+	from, err := strconv.Atoi(ctx.DefaultQuery("from", strconv.Itoa(to-1)))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision from"})
+		return
+	}
+
+	textFrom, err := message.GetRevisionText(from)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	textTo, err := message.GetRevisionText(to)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"idMessage": message.ID,
+		"from":      from,
+		"to":        to,
+		"diff":      utils.DiffWords(textFrom, textTo),
+	})
+}
+
+// preCheckReadMessage returns message from url param idMessage if user
+// has read access to one of the topics of this message
+func (m *MessagesController) preCheckReadMessage(ctx *gin.Context) (models.Message, error) {
+	idMessageIn, err := GetParam(ctx, "idMessage")
+	if err != nil {
+		return models.Message{}, err
+	}
+
+	message := models.Message{}
+	err = message.FindByID(idMessageIn)
+	if err != nil {
+		e := fmt.Errorf("Message %s does not exist", idMessageIn)
+		ctx.JSON(http.StatusNotFound, gin.H{"error": e.Error()})
+		return message, e
+	}
+
+	user, err := PreCheckUser(ctx)
+	if err != nil {
+		return message, err
+	}
+
+	if m.isReadAccessOnMessage(message, user) {
+		return message, nil
+	}
+
+	e := fmt.Errorf("No Read Access to message %s", message.ID)
+	ctx.JSON(http.StatusForbidden, gin.H{"error": e.Error()})
+	return message, e
+}
+
+// isReadAccessOnMessage returns true if user has read access
+// to one of the topics of message
+func (m *MessagesController) isReadAccessOnMessage(message models.Message, user models.User) bool {
+	for _, topicName := range message.Topics {
+		topic := models.Topic{}
+		if err := topic.FindByTopic(topicName, true); err != nil {
+			continue
+		}
+		if topic.IsUserReadAccess(user) {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *MessagesController) moveMessage(ctx *gin.Context, messageIn *messageJSON, message models.Message, user models.User, topic models.Topic) {
 	// Check if user can delete msg on from topic
 	_, err := m.checkBeforeDelete(ctx, message, user)
@@ -630,6 +1334,7 @@ func (m *MessagesController) moveMessage(ctx *gin.Context, messageIn *messageJSO
 	}
 
 	info := ""
+	oldTopic := message.Topics[0]
 	if messageIn.Action == "move" {
 		err := message.Move(user, topic)
 		if err != nil {
@@ -642,14 +1347,54 @@ func (m *MessagesController) moveMessage(ctx *gin.Context, messageIn *messageJSO
 		ctx.AbortWithError(http.StatusBadRequest, errors.New("Invalid action : "+messageIn.Action))
 		return
 	}
-	go models.WSMessage(&models.WSMessageJSON{Action: messageIn.Action, Username: user.Username, Message: message})
+	go models.WSMessage(&models.WSMessageJSON{Action: messageIn.Action, Username: user.Username, Topic: oldTopic, Message: message})
 	ctx.JSON(http.StatusCreated, gin.H{"info": info})
 }
 
+// shareOrUnshare adds or removes topic on thread of message. RW access on topic
+// is checked before, share needs read access on message too
+func (m *MessagesController) shareOrUnshare(ctx *gin.Context, messageIn *messageJSON, message models.Message, user models.User, topic models.Topic) {
+	if message.InReplyOfIDRoot != "" {
+		if err := message.FindByID(message.InReplyOfIDRoot); err != nil {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Root message %s does not exist", message.InReplyOfIDRoot)})
+			return
+		}
+	}
+
+	var err error
+	info := ""
+	if messageIn.Action == "share" {
+		if !m.isReadAccessOnMessage(message, user) {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("No Read Access to message %s", message.ID)})
+			return
+		}
+		err = message.Share(topic)
+		info = fmt.Sprintf("Thread shared on %s", topic.Topic)
+	} else {
+		err = message.Unshare(topic)
+		info = fmt.Sprintf("Thread unshared from %s", topic.Topic)
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	go models.WSMessage(&models.WSMessageJSON{Action: messageIn.Action, Username: user.Username, Topic: topic.Topic, Message: message})
+	ctx.JSON(http.StatusCreated, gin.H{"info": info, "message": message})
+}
+
 func (m *MessagesController) getTopicNameFromAction(username, action string) string {
 	return "/Private/" + username + "/" + strings.Title(action) + "s"
 }
 
+// topicOfMessage returns topicName if message is on it: a shared message is
+// updated from any of its topics. Otherwise, returns first topic of message
+func (m *MessagesController) topicOfMessage(ctx *gin.Context, topicName string, message models.Message) string {
+	if utils.ArrayContains(message.Topics, topicName) {
+		return topicName
+	}
+	return m.inverseIfDMTopic(ctx, message.Topics[0])
+}
+
 func (m *MessagesController) inverseIfDMTopic(ctx *gin.Context, topicName string) string {
 	if !strings.HasPrefix(topicName, "/Private/") {
 		return topicName
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ovh/tat/models"
+)
+
+// NotificationsController contains all methods about notifications of current user
+type NotificationsController struct{}
+
+type notificationsJSON struct {
+	Count         int              `json:"count"`
+	ByTopic       map[string]int   `json:"byTopic"`
+	Notifications []models.Message `json:"notifications"`
+}
+
+type notificationsReadJSON struct {
+	IDs     []string `json:"ids"`
+	All     bool     `json:"all"`
+	DateMax int64    `json:"dateMax"`
+}
+
+// List returns unread notifications of current user, newest first, with
+// number of unread notifications, and by topic of message at origin of notification
+func (*NotificationsController) List(ctx *gin.Context) {
+	user, e := PreCheckUser(ctx)
+	if e != nil {
+		return
+	}
+	skip, err := strconv.Atoi(ctx.DefaultQuery("skip", "0"))
+	if err != nil || skip < 0 {
+		skip = 0
+	}
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "100"))
+	if err != nil || limit < 0 {
+		limit = 100
+	}
+
+	count, err := user.CountUnreadNotifications()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error while counting notifications"})
+		return
+	}
+	notifications, err := user.ListUnreadNotifications(skip, limit)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error while listing notifications"})
+		return
+	}
+	if notifications == nil {
+		notifications = []models.Message{}
+	}
+	ctx.JSON(http.StatusOK, &notificationsJSON{Count: count.Count, ByTopic: count.ByTopic, Notifications: notifications})
+}
+
+// Count returns number of unread notifications of current user, and by topic
+func (*NotificationsController) Count(ctx *gin.Context) {
+	user, e := PreCheckUser(ctx)
+	if e != nil {
+		return
+	}
+	count, err := user.CountUnreadNotifications()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error while counting notifications"})
+		return
+	}
+	ctx.JSON(http.StatusOK, count)
+}
+
+// Read marks notifications of current user as read: notifications with ids,
+// all notifications, or notifications created before dateMax
+func (*NotificationsController) Read(ctx *gin.Context) {
+	var readIn notificationsReadJSON
+	if err := ctx.BindJSON(&readIn); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid body, ids, all or dateMax is expected"})
+		return
+	}
+	user, e := PreCheckUser(ctx)
+	if e != nil {
+		return
+	}
+
+	var err error
+	if len(readIn.IDs) > 0 {
+		err = user.MarkNotificationsRead(readIn.IDs)
+	} else if readIn.All || readIn.DateMax != 0 {
+		err = user.MarkAllNotificationsRead(readIn.DateMax)
+	} else {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid body, ids, all or dateMax is expected"})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	count, err := user.CountUnreadNotifications()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error while counting notifications"})
+		return
+	}
+	go models.WSNotifications(&models.WSNotificationsJSON{Username: user.Username, Count: count.Count, ByTopic: count.ByTopic})
+	ctx.JSON(http.StatusCreated, gin.H{"info": "Notifications marked as read", "count": count.Count, "byTopic": count.ByTopic})
+}
@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ovh/tat/models"
+)
+
+// ScheduledMessagesController contains all methods about scheduled messages manipulation
+type ScheduledMessagesController struct{}
+
+type scheduledMessagesJSON struct {
+	Count             int                       `json:"count"`
+	ScheduledMessages []models.ScheduledMessage `json:"scheduledMessages"`
+}
+
+type scheduledMessageJSON struct {
+	Text        string         `json:"text"`
+	DatePublish int64          `json:"datePublish"`
+	Labels      []models.Label `json:"labels"`
+}
+
+func (*ScheduledMessagesController) buildCriteria(ctx *gin.Context) *models.ScheduledMessageCriteria {
+	c := models.ScheduledMessageCriteria{}
+	skip, e := strconv.Atoi(ctx.DefaultQuery("skip", "0"))
+	if e != nil {
+		skip = 0
+	}
+	c.Skip = skip
+	limit, e2 := strconv.Atoi(ctx.DefaultQuery("limit", "100"))
+	if e2 != nil {
+		limit = 10
+	}
+	c.Limit = limit
+	c.Topic = ctx.Query("topic")
+	c.Status = ctx.Query("status")
+	return &c
+}
+
+// List returns scheduled messages of current user
+func (m *ScheduledMessagesController) List(ctx *gin.Context) {
+	user, e := PreCheckUser(ctx)
+	if e != nil {
+		return
+	}
+
+	criteria := m.buildCriteria(ctx)
+	criteria.Username = user.Username
+
+	count, scheduled, err := models.ListScheduledMessages(criteria)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, &scheduledMessagesJSON{Count: count, ScheduledMessages: scheduled})
+}
+
+func (m *ScheduledMessagesController) preCheckScheduled(ctx *gin.Context) (models.ScheduledMessage, models.User, error) {
+	var scheduled = models.ScheduledMessage{}
+	user, e := PreCheckUser(ctx)
+	if e != nil {
+		return scheduled, user, e
+	}
+
+	idScheduled, err := GetParam(ctx, "idScheduled")
+	if err != nil {
+		return scheduled, user, err
+	}
+
+	if err := scheduled.FindByIDAndUsername(idScheduled, user.Username); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Scheduled message %s does not exist", idScheduled)})
+		return scheduled, user, err
+	}
+	return scheduled, user, nil
+}
+
+// Update text, datePublish or labels of a scheduled message
+func (m *ScheduledMessagesController) Update(ctx *gin.Context) {
+	var scheduledIn scheduledMessageJSON
+	ctx.Bind(&scheduledIn)
+
+	scheduled, user, err := m.preCheckScheduled(ctx)
+	if err != nil {
+		return
+	}
+
+	if err := scheduled.Update(user, scheduledIn.Text, scheduledIn.DatePublish, scheduledIn.Labels); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"info": "Scheduled message updated", "scheduledMessage": scheduled})
+}
+
+// Delete cancels a scheduled message
+func (m *ScheduledMessagesController) Delete(ctx *gin.Context) {
+	scheduled, _, err := m.preCheckScheduled(ctx)
+	if err != nil {
+		return
+	}
+
+	if err := scheduled.Delete(); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"info": fmt.Sprintf("Scheduled message %s deleted", scheduled.ID)})
+}
@@ -5,11 +5,13 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/gin-gonic/gin"
 	"github.com/ovh/tat/models"
+	"github.com/ovh/tat/utils"
 )
 
 // StatsController contains all methods about stats
@@ -250,3 +252,86 @@ func (*StatsController) CheckHeaders(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, g)
 }
+
+// RateLimits returns counters of rate limits by scope, and buckets with most
+// messages rejected, 100 by default with query param limit (admin route)
+func (*StatsController) RateLimits(ctx *gin.Context) {
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+	scopes, buckets, err := models.GetRateLimitsStats(limit)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error while computing stats of rate limits"})
+		return
+	}
+	now := time.Now()
+	ctx.JSON(http.StatusOK, gin.H{
+		"date":      now.Unix(),
+		"dateHuman": now,
+		"scopes":    scopes,
+		"buckets":   buckets,
+	})
+}
+
+// default date range of analytics, in number of buckets
+const analyticsDefaultBuckets = 30
+
+// Analytics returns number of messages of a topic by hour, day or week,
+// and by label, tag or author with groupBy. Read access on topic is needed
+func (*StatsController) Analytics(ctx *gin.Context) {
+	messagesCtrl := &MessagesController{}
+	criteria := messagesCtrl.buildCriteria(ctx)
+	topicIn, err := GetParam(ctx, "topic")
+	if err != nil {
+		return
+	}
+
+	period := ctx.DefaultQuery("period", "day")
+	groupBy := ctx.Query("groupBy")
+	size := utils.BucketSize(period)
+	if size == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid period " + period + ", period is hour, day or week"})
+		return
+	}
+	if !models.IsValidAnalyticsGroupBy(groupBy) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid groupBy " + groupBy + ", groupBy is label, tag or author"})
+		return
+	}
+
+	dateMax := time.Now().Unix()
+	if criteria.DateMaxCreation != "" {
+		if dateMax, err = strconv.ParseInt(criteria.DateMaxCreation, 10, 64); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dateMaxCreation " + criteria.DateMaxCreation})
+			return
+		}
+	}
+	dateMin := dateMax - (analyticsDefaultBuckets-1)*size
+	if criteria.DateMinCreation != "" {
+		if dateMin, err = strconv.ParseInt(criteria.DateMinCreation, 10, 64); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dateMinCreation " + criteria.DateMinCreation})
+			return
+		}
+	}
+
+	if err := messagesCtrl.checkAttributeFilters(ctx, criteria); err != nil {
+		return
+	}
+	criteria.Topic = topicIn
+	topic, _, err := messagesCtrl.preCheckTopicRead(ctx, criteria)
+	if err != nil {
+		return
+	}
+
+	buckets, err := models.AnalyticsMessages(criteria, period, groupBy, dateMin, dateMax)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"topic":   topic.Topic,
+		"period":  period,
+		"groupBy": groupBy,
+		"buckets": buckets,
+	})
+}
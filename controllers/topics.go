@@ -24,7 +24,8 @@ type topicsJSON struct {
 }
 
 type topicJSON struct {
-	Topic *models.Topic `json:"topic"`
+	Topic  *models.Topic    `json:"topic"`
+	Pinned []models.Message `json:"pinned,omitempty"`
 }
 
 type paramTopicUserJSON struct {
@@ -152,6 +153,11 @@ func (t *TopicsController) OneTopic(ctx *gin.Context) {
 		return
 	}
 	out := &topicJSON{Topic: topic}
+	out.Pinned, err = topic.PinnedMessages()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	ctx.JSON(http.StatusOK, out)
 }
 
@@ -264,6 +270,21 @@ func (t *TopicsController) preCheckUserAdminOnTopic(ctx *gin.Context, topicName
 	return topic, nil
 }
 
+// preCheckAdminOrPrivateTopic returns topic if current user is admin on it,
+// or if topic is one of its Private topics
+func (t *TopicsController) preCheckAdminOrPrivateTopic(ctx *gin.Context, topicName string) (models.Topic, error) {
+	username := utils.GetCtxUsername(ctx)
+	if topicName == "/Private/"+username || strings.HasPrefix(topicName, "/Private/"+username+"/") {
+		topic := models.Topic{}
+		if err := topic.FindByTopic(topicName, false); err != nil {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Topic %s does not exist", topicName)})
+			return topic, err
+		}
+		return topic, nil
+	}
+	return t.preCheckUserAdminOnTopic(ctx, topicName)
+}
+
 // AddRoUser add a readonly user on selected topic
 func (t *TopicsController) AddRoUser(ctx *gin.Context) {
 	var paramJSON paramTopicUserJSON
@@ -567,3 +588,191 @@ func (t *TopicsController) SetParam(ctx *gin.Context) {
 	}
 	ctx.JSON(http.StatusCreated, gin.H{"info": fmt.Sprintf("Topic %s updated", topic.Topic)})
 }
+
+type retentionJSON struct {
+	Topic     string `json:"topic"`
+	MaxAge    int    `json:"maxAge"`
+	MaxCount  int    `json:"maxCount"`
+	Recursive bool   `json:"recursive"`
+}
+
+// SetRetention update retention of a topic: max age in days and max count of threads
+// admin only, except on Private topic
+func (t *TopicsController) SetRetention(ctx *gin.Context) {
+	var retentionJSON retentionJSON
+	ctx.Bind(&retentionJSON)
+
+	topic, err := t.preCheckAdminOrPrivateTopic(ctx, retentionJSON.Topic)
+	if err != nil {
+		return
+	}
+
+	err = topic.SetRetention(utils.GetCtxUsername(ctx), retentionJSON.Recursive, retentionJSON.MaxAge, retentionJSON.MaxCount)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{"info": fmt.Sprintf("Retention on topic %s updated", topic.Topic)})
+}
+
+type labelsCatalogJSON struct {
+	Topic        string              `json:"topic"`
+	Labels       []models.TopicLabel `json:"labels"`
+	StrictLabels bool                `json:"strictLabels"`
+	Recursive    bool                `json:"recursive"`
+}
+
+// SetLabelsCatalog replaces labels catalog of a topic: text, color, description
+// and group of labels, and if only these labels can be used on messages
+// admin only, except on Private topic
+func (t *TopicsController) SetLabelsCatalog(ctx *gin.Context) {
+	var catalogJSON labelsCatalogJSON
+	ctx.Bind(&catalogJSON)
+
+	topic, err := t.preCheckAdminOrPrivateTopic(ctx, catalogJSON.Topic)
+	if err != nil {
+		return
+	}
+
+	err = topic.SetLabelsCatalog(utils.GetCtxUsername(ctx), catalogJSON.Recursive, catalogJSON.Labels, catalogJSON.StrictLabels)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{"info": fmt.Sprintf("Labels catalog on topic %s updated", topic.Topic), "labelsCatalog": topic.LabelsCatalog})
+}
+
+type rulesJSON struct {
+	Topic string             `json:"topic"`
+	Rules []models.TopicRule `json:"rules"`
+	Limit int                `json:"limit"`
+}
+
+// SetRules replaces rules of a topic, applied on new messages
+// admin only, except on Private topic
+func (t *TopicsController) SetRules(ctx *gin.Context) {
+	var rulesIn rulesJSON
+	ctx.Bind(&rulesIn)
+
+	topic, err := t.preCheckAdminOrPrivateTopic(ctx, rulesIn.Topic)
+	if err != nil {
+		return
+	}
+	if err := topic.SetRules(utils.GetCtxUsername(ctx), rulesIn.Rules); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{"info": fmt.Sprintf("Rules on topic %s updated", topic.Topic), "rules": topic.Rules})
+}
+
+type validationJSON struct {
+	Topic      string                  `json:"topic"`
+	Validation *models.TopicValidation `json:"validation"`
+}
+
+// SetValidation replaces validation of new messages of a topic, an empty validation removes it
+// admin only, except on Private topic
+func (t *TopicsController) SetValidation(ctx *gin.Context) {
+	var validationIn validationJSON
+	ctx.Bind(&validationIn)
+
+	topic, err := t.preCheckAdminOrPrivateTopic(ctx, validationIn.Topic)
+	if err != nil {
+		return
+	}
+	if err := topic.SetValidation(utils.GetCtxUsername(ctx), validationIn.Validation); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{"info": fmt.Sprintf("Validation on topic %s updated", topic.Topic), "validation": topic.Validation})
+}
+
+type rateLimitJSON struct {
+	Topic     string                 `json:"topic"`
+	RateLimit *models.TopicRateLimit `json:"rateLimit"`
+}
+
+// SetRateLimit replaces rate limits of messages of a topic, overriding rate limits of Tat
+// admin only, except on Private topic
+func (t *TopicsController) SetRateLimit(ctx *gin.Context) {
+	var rateLimitIn rateLimitJSON
+	ctx.Bind(&rateLimitIn)
+
+	topic, err := t.preCheckAdminOrPrivateTopic(ctx, rateLimitIn.Topic)
+	if err != nil {
+		return
+	}
+	if err := topic.SetRateLimit(utils.GetCtxUsername(ctx), rateLimitIn.RateLimit); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{"info": fmt.Sprintf("Rate limit on topic %s updated", topic.Topic), "rateLimit": topic.RateLimit})
+}
+
+// DryRunRules returns newest messages of a topic matching rules given, or rules
+// of topic if none given, with actions of rules. Messages are not updated
+// admin only, except on Private topic
+func (t *TopicsController) DryRunRules(ctx *gin.Context) {
+	var rulesIn rulesJSON
+	ctx.Bind(&rulesIn)
+
+	topic, err := t.preCheckAdminOrPrivateTopic(ctx, rulesIn.Topic)
+	if err != nil {
+		return
+	}
+	rules := rulesIn.Rules
+	if rules == nil {
+		rules = topic.Rules
+	}
+	results, err := topic.DryRunRules(rules, rulesIn.Limit)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"rules": rules, "matches": results})
+}
+
+// Export streams topic, its sub-topics and all their messages, as JSON Lines
+// Tat admin only
+func (t *TopicsController) Export(ctx *gin.Context) {
+	topicRequest, err := GetParam(ctx, "topic")
+	if err != nil {
+		return
+	}
+	if !models.IsTopicExists(topicRequest) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Topic %s does not exist", topicRequest)})
+		return
+	}
+
+	ctx.Header("Content-Type", "application/x-ndjson")
+	ctx.Writer.WriteHeader(http.StatusOK)
+	result, err := models.ExportTopics(topicRequest, ctx.Writer)
+	if err != nil {
+		// response is already started, error can only be logged
+		log.Errorf("Error while exporting topic %s: %s", topicRequest, err)
+		return
+	}
+	log.Infof("Topic %s exported by %s: %d topics, %d messages", topicRequest, utils.GetCtxUsername(ctx), result.Topics, result.Messages)
+}
+
+// Import inserts topics and messages of body, exported by Export. Query params:
+// remap=true gives new ids, fromPrefix and toPrefix rename topics on import
+// Tat admin only
+func (t *TopicsController) Import(ctx *gin.Context) {
+	options := models.ImportOptions{
+		Remap:      ctx.Query("remap") == "true",
+		FromPrefix: ctx.Query("fromPrefix"),
+		ToPrefix:   ctx.Query("toPrefix"),
+	}
+	if (options.FromPrefix == "") != (options.ToPrefix == "") {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "fromPrefix and toPrefix must be given together"})
+		return
+	}
+
+	result, err := models.ImportTopics(ctx.Request.Body, utils.GetCtxUsername(ctx), options)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "topics": result.Topics, "messages": result.Messages})
+		return
+	}
+	ctx.JSON(http.StatusCreated, result)
+}
@@ -728,3 +728,49 @@ func (*UsersController) checkTopics(fixTopics bool, userToCheck models.User) str
 	}
 	return topicsInfo
 }
+
+type emailNotificationsJSON struct {
+	Mode string `json:"mode" binding:"required"`
+}
+
+// SetEmailNotifications updates emails of notifications of current user:
+// off, immediate, hourly or daily
+func (*UsersController) SetEmailNotifications(ctx *gin.Context) {
+	var emailNotificationsIn emailNotificationsJSON
+	if err := ctx.BindJSON(&emailNotificationsIn); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid body, mode is expected"})
+		return
+	}
+	user, err := PreCheckUser(ctx)
+	if err != nil {
+		return
+	}
+
+	if err := user.SetEmailNotifications(emailNotificationsIn.Mode); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{
+		"info":               fmt.Sprintf("Email notifications set to %s", user.EmailNotifications.Mode),
+		"emailNotifications": user.EmailNotifications,
+	})
+}
+
+// UnsubscribeEmailNotifications disables emails of notifications of a user,
+// from unsubscribe link of an email
+func (*UsersController) UnsubscribeEmailNotifications(ctx *gin.Context) {
+	username, err := GetParam(ctx, "username")
+	if err != nil {
+		return
+	}
+	token, err := GetParam(ctx, "token")
+	if err != nil {
+		return
+	}
+
+	if err := models.UnsubscribeEmailNotifications(username, token); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"info": fmt.Sprintf("Email notifications disabled for %s", username)})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ovh/tat/models"
+	"github.com/spf13/cobra"
+)
+
+var exportOutput string
+
+// The export command writes a topic, its sub-topics and their messages as JSON Lines
+var exportCmd = &cobra.Command{
+	Use:   "export <topic>",
+	Short: "Export a topic and its sub-topics as JSON Lines",
+	Long:  "Export a topic, its sub-topics and all their messages as JSON Lines, on stdout or in a file.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatalf("Invalid usage: tat export <topic>")
+		}
+		initConfig()
+		models.NewStore()
+
+		var w io.Writer = os.Stdout
+		if exportOutput != "" {
+			f, err := os.Create(exportOutput)
+			if err != nil {
+				log.Fatalf("Error while creating %s: %s", exportOutput, err)
+			}
+			defer f.Close()
+			w = f
+		}
+
+		result, err := models.ExportTopics(args[0], w)
+		if err != nil {
+			log.Fatalf("Error while exporting topic %s: %s", args[0], err)
+		}
+		log.Infof("%d topics and %d messages exported", result.Topics, result.Messages)
+	},
+}
+
+var importOptions models.ImportOptions
+var importUsername string
+
+// The import command reads a file written by export command, or stdin
+var importCmd = &cobra.Command{
+	Use:   "import [file]",
+	Short: "Import topics exported by tat export",
+	Long:  "Import topics and messages exported by tat export, from a file or from stdin.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 1 {
+			log.Fatalf("Invalid usage: tat import [file]")
+		}
+		if (importOptions.FromPrefix == "") != (importOptions.ToPrefix == "") {
+			log.Fatalf("--from-prefix and --to-prefix must be given together")
+		}
+		initConfig()
+		models.NewStore()
+
+		var r io.Reader = os.Stdin
+		if len(args) == 1 {
+			f, err := os.Open(args[0])
+			if err != nil {
+				log.Fatalf("Error while opening %s: %s", args[0], err)
+			}
+			defer f.Close()
+			r = f
+		}
+
+		result, err := models.ImportTopics(r, importUsername, importOptions)
+		if err != nil {
+			log.Fatalf("Error while importing, %d topics and %d messages read: %s", result.Topics, result.Messages, err)
+		}
+		log.Infof("%d topics and %d messages imported", result.Topics, result.Messages)
+	},
+}
+
+func init() {
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "Output file, stdout if empty")
+
+	importCmd.Flags().BoolVarP(&importOptions.Remap, "remap", "", false, "Give new ids to topics and messages, instead of ids of export")
+	importCmd.Flags().StringVarP(&importOptions.FromPrefix, "from-prefix", "", "", "Rename topics starting with this prefix, with --to-prefix")
+	importCmd.Flags().StringVarP(&importOptions.ToPrefix, "to-prefix", "", "", "New prefix of topics renamed with --from-prefix")
+	importCmd.Flags().StringVarP(&importUsername, "username", "", "tat", "Username written in history of imported topics")
+}
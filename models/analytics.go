@@ -0,0 +1,134 @@
+package models
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ovh/tat/utils"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MaxAnalyticsBuckets is the max number of buckets returned by AnalyticsMessages
+const MaxAnalyticsBuckets = 1000
+
+// analyticsGroupFields are fields of messages used to group messages of a bucket
+var analyticsGroupFields = map[string]string{
+	"label":  "labels.text",
+	"tag":    "tags",
+	"author": "author.username",
+}
+
+// analyticsUnwindFields are arrays to unwind before grouping messages
+var analyticsUnwindFields = map[string]string{
+	"label": "labels",
+	"tag":   "tags",
+}
+
+// AnalyticsBucket struct, number of messages created in a period,
+// and by label, tag or author if messages are grouped
+type AnalyticsBucket struct {
+	Date   int64          `json:"date"`
+	Count  int            `json:"count"`
+	Groups map[string]int `json:"groups,omitempty"`
+}
+
+// IsValidAnalyticsGroupBy returns true if groupBy is empty, label, tag or author
+func IsValidAnalyticsGroupBy(groupBy string) bool {
+	_, ok := analyticsGroupFields[groupBy]
+	return ok || groupBy == ""
+}
+
+// AnalyticsMessages returns number of messages matching criteria, by bucket of period
+// hour, day or week, from dateMin to dateMax. Buckets are in UTC, a week starts on
+// Monday. With groupBy label, tag or author, each bucket contains number of messages
+// by label, tag or author: a message with many labels is counted in each of them
+func AnalyticsMessages(criteria *MessageCriteria, period, groupBy string, dateMin, dateMax int64) ([]AnalyticsBucket, error) {
+	size := utils.BucketSize(period)
+	if size == 0 {
+		return nil, fmt.Errorf("Invalid period %s, period is hour, day or week", period)
+	}
+	if !IsValidAnalyticsGroupBy(groupBy) {
+		return nil, fmt.Errorf("Invalid groupBy %s, groupBy is label, tag or author", groupBy)
+	}
+	first, last := utils.BucketStart(dateMin, size), utils.BucketStart(dateMax, size)
+	if last < first {
+		return nil, fmt.Errorf("Invalid date range, dateMinCreation must be before dateMaxCreation")
+	}
+	if (last-first)/size+1 > MaxAnalyticsBuckets {
+		return nil, fmt.Errorf("Too many buckets, %d buckets max, reduce date range or use a longer period", MaxAnalyticsBuckets)
+	}
+
+	c := *criteria
+	c.DateMinCreation = fmt.Sprintf("%d", dateMin)
+	c.DateMaxCreation = fmt.Sprintf("%d", dateMax)
+	match := bson.M{"$match": buildMessageCriteria(&c)}
+
+	// start of bucket, as utils.BucketStart
+	bucket := bson.M{"$subtract": []interface{}{
+		"$dateCreation",
+		bson.M{"$mod": []interface{}{
+			bson.M{"$subtract": []interface{}{"$dateCreation", utils.BucketOffset(size)}},
+			size,
+		}},
+	}}
+
+	var totals []struct {
+		Date  int64 `bson:"_id"`
+		Count int   `bson:"count"`
+	}
+	err := Store().clMessages.Pipe([]bson.M{
+		match,
+		bson.M{"$group": bson.M{"_id": bucket, "count": bson.M{"$sum": 1}}},
+	}).All(&totals)
+	if err != nil {
+		log.Errorf("Error while computing analytics of messages %s", err)
+		return nil, err
+	}
+
+	buckets := make(map[int64]*AnalyticsBucket)
+	for date := first; date <= last; date += size {
+		buckets[date] = &AnalyticsBucket{Date: date}
+		if groupBy != "" {
+			buckets[date].Groups = map[string]int{}
+		}
+	}
+	for _, t := range totals {
+		if b, ok := buckets[t.Date]; ok {
+			b.Count = t.Count
+		}
+	}
+
+	if groupBy != "" {
+		field := analyticsGroupFields[groupBy]
+		pipeline := []bson.M{match}
+		if unwind, ok := analyticsUnwindFields[groupBy]; ok {
+			pipeline = append(pipeline, bson.M{"$unwind": "$" + unwind})
+		}
+		var groups []struct {
+			ID struct {
+				Date int64  `bson:"date"`
+				Key  string `bson:"key"`
+			} `bson:"_id"`
+			Count int `bson:"count"`
+		}
+		pipeline = append(pipeline, bson.M{"$group": bson.M{
+			"_id":   bson.M{"date": bucket, "key": "$" + field},
+			"count": bson.M{"$sum": 1},
+		}})
+		if err := Store().clMessages.Pipe(pipeline).All(&groups); err != nil {
+			log.Errorf("Error while computing analytics of messages by %s %s", groupBy, err)
+			return nil, err
+		}
+		for _, g := range groups {
+			if b, ok := buckets[g.ID.Date]; ok {
+				b.Groups[g.ID.Key] = g.Count
+			}
+		}
+	}
+
+	result := make([]AnalyticsBucket, 0, len(buckets))
+	for date := first; date <= last; date += size {
+		result = append(result, *buckets[date])
+	}
+	return result, nil
+}
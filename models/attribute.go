@@ -0,0 +1,203 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ovh/tat/utils"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const lengthAttributeKey = 64
+
+// Attribute struct, a key with a string, number or bool value
+type Attribute struct {
+	Key   string      `bson:"key"   json:"key"`
+	Value interface{} `bson:"value" json:"value"`
+}
+
+// Attributes of a message, stored as an array to be indexed,
+// in json as an object: {"env": "prod", "latency": 230}
+type Attributes []Attribute
+
+// AttributeFilter is a filter on attribute Key of messages,
+// Operator is one of =, !=, >, >=, <, <=
+type AttributeFilter struct {
+	Key      string
+	Operator string
+	Value    string
+}
+
+var attributeOperators = map[string]string{
+	"=":  "$eq",
+	"!=": "$ne",
+	">":  "$gt",
+	">=": "$gte",
+	"<":  "$lt",
+	"<=": "$lte",
+}
+
+// MarshalJSON returns attributes as a json object
+func (attributes Attributes) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(attributes))
+	for _, a := range attributes {
+		m[a.Key] = a.Value
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON reads attributes from a json object, sorted by key
+func (attributes *Attributes) UnmarshalJSON(data []byte) error {
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	*attributes = Attributes{}
+	for _, k := range keys {
+		*attributes = append(*attributes, Attribute{Key: k, Value: m[k]})
+	}
+	return nil
+}
+
+// checkAttributes returns an error if a key is invalid or a value is not
+// a string, a number or a bool. A nil value is allowed only if allowNil,
+// to remove an attribute
+func checkAttributes(attributes Attributes, allowNil bool) error {
+	for _, a := range attributes {
+		if a.Key == "" || len(a.Key) > lengthAttributeKey || strings.ContainsAny(a.Key, ".$ <>=!") {
+			return fmt.Errorf("Invalid attribute key %s, a key is a word of %d characters max, without . $ < > = !", a.Key, lengthAttributeKey)
+		}
+		switch a.Value.(type) {
+		case string, float64, bool, int, int64:
+		case nil:
+			if !allowNil {
+				return fmt.Errorf("Invalid attribute %s, value can't be null", a.Key)
+			}
+		default:
+			return fmt.Errorf("Invalid attribute %s, value must be a string, a number or a bool", a.Key)
+		}
+	}
+	return nil
+}
+
+// SetAttributes adds or updates attributes of a message.
+// An attribute with a nil value is removed
+func (message *Message) SetAttributes(attributes Attributes) error {
+	if err := checkAttributes(attributes, true); err != nil {
+		return err
+	}
+
+	var newAttributes = Attributes{}
+	for _, a := range message.Attributes {
+		if !attributes.contains(a.Key) {
+			newAttributes = append(newAttributes, a)
+		}
+	}
+	for _, a := range attributes {
+		if a.Value != nil {
+			newAttributes = append(newAttributes, a)
+		}
+	}
+
+	err := Store().clMessages.Update(
+		bson.M{"_id": message.ID},
+		bson.M{"$set": bson.M{"dateUpdate": time.Now().Unix(), "attributes": newAttributes}})
+	if err != nil {
+		return err
+	}
+	message.Attributes = newAttributes
+	return nil
+}
+
+func (attributes Attributes) contains(key string) bool {
+	for _, a := range attributes {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func buildAttributeCriteria(filter AttributeFilter) bson.M {
+	value := utils.ParseAttributeValue(filter.Value)
+	op := attributeOperators[filter.Operator]
+
+	// value "200" matches number 200 and string "200"
+	equal := bson.M{"key": filter.Key, "value": bson.M{"$in": []interface{}{value, filter.Value}}}
+	switch op {
+	case "$eq":
+		return bson.M{"attributes": bson.M{"$elemMatch": equal}}
+	case "$ne":
+		// message without attribute key matches too
+		return bson.M{"attributes": bson.M{"$not": bson.M{"$elemMatch": equal}}}
+	}
+	return bson.M{"attributes": bson.M{"$elemMatch": bson.M{"key": filter.Key, "value": bson.M{op: value}}}}
+}
+
+// IsValidAttributeFilter returns true if operator of filter is known
+func IsValidAttributeFilter(filter AttributeFilter) bool {
+	_, ok := attributeOperators[filter.Operator]
+	return ok && filter.Key != ""
+}
+
+// sortAttribute returns key of attribute to sort on, and order (1 or -1),
+// for sortBy attr.key or -attr.key
+func sortAttribute(sortBy string) (string, int, bool) {
+	order := 1
+	if strings.HasPrefix(sortBy, "-") {
+		order = -1
+		sortBy = sortBy[1:]
+	}
+	if !strings.HasPrefix(sortBy, utils.AttributePrefix) || len(sortBy) == len(utils.AttributePrefix) {
+		return "", 0, false
+	}
+	return sortBy[len(utils.AttributePrefix):], order, true
+}
+
+// IsSortByAttribute returns true if sortBy is attr.key or -attr.key
+func IsSortByAttribute(sortBy string) bool {
+	_, _, ok := sortAttribute(sortBy)
+	return ok
+}
+
+// listMessagesSortedByAttribute lists messages, sorted on value of an attribute.
+// Messages without this attribute are last
+func listMessagesSortedByAttribute(criteria *MessageCriteria) ([]Message, error) {
+	var messages []Message
+	key, order, _ := sortAttribute(criteria.SortBy)
+
+	pipeline := []bson.M{
+		bson.M{"$match": buildMessageCriteria(criteria)},
+		bson.M{"$addFields": bson.M{
+			"sortValue": bson.M{"$arrayElemAt": []interface{}{
+				bson.M{"$filter": bson.M{
+					"input": bson.M{"$ifNull": []interface{}{"$attributes", []interface{}{}}},
+					"as":    "attribute",
+					"cond":  bson.M{"$eq": []string{"$$attribute.key", key}},
+				}}, 0}},
+			"hasSortValue": bson.M{"$in": []interface{}{key, bson.M{"$ifNull": []interface{}{"$attributes.key", []interface{}{}}}}},
+		}},
+		bson.M{"$sort": bson.D{
+			{Name: "hasSortValue", Value: -1},
+			{Name: "sortValue.value", Value: order},
+			{Name: "dateCreation", Value: -1},
+			{Name: "_id", Value: -1},
+		}},
+		bson.M{"$skip": criteria.Skip},
+	}
+	if criteria.Limit > 0 {
+		pipeline = append(pipeline, bson.M{"$limit": criteria.Limit})
+	}
+
+	err := Store().clMessages.Pipe(pipeline).All(&messages)
+	return messages, err
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ovh/tat/utils"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MaxBoardColumns is the max number of columns of a board
+const MaxBoardColumns = 20
+
+// BoardColumn struct, root messages with label of column
+type BoardColumn struct {
+	Label    string    `json:"label"`
+	Count    int       `json:"count"`
+	Messages []Message `json:"messages"`
+}
+
+// ListBoard returns root messages matching criteria by column: for each label
+// of columns, count of messages with this label, and its criteria.Limit newest
+// messages after criteria.Skip. A message with labels of many columns is in
+// each of them. Board is built with one aggregation
+func ListBoard(criteria *MessageCriteria, columns []string) ([]BoardColumn, error) {
+	c := *criteria
+	c.OnlyMsgRoot = "true"
+
+	facets := bson.M{}
+	for i, label := range columns {
+		match := bson.M{"$match": bson.M{"labels.text": label}}
+		facets[fmt.Sprintf("count%d", i)] = []bson.M{match, bson.M{"$count": "count"}}
+
+		page := []bson.M{
+			match,
+			bson.M{"$sort": bson.D{{Name: "dateCreation", Value: -1}, {Name: "_id", Value: -1}}},
+			bson.M{"$skip": c.Skip},
+		}
+		if c.Limit > 0 {
+			page = append(page, bson.M{"$limit": c.Limit})
+		}
+		facets[fmt.Sprintf("messages%d", i)] = page
+	}
+
+	pipeline := []bson.M{
+		bson.M{"$match": bson.M{"$and": []bson.M{
+			buildMessageCriteria(&c),
+			bson.M{"labels.text": bson.M{"$in": columns}},
+		}}},
+		bson.M{"$facet": facets},
+	}
+
+	var result map[string]bson.Raw
+	if err := Store().clMessages.Pipe(pipeline).One(&result); err != nil {
+		log.Errorf("Error while building board %s", err)
+		return nil, err
+	}
+
+	var terms []string
+	if c.Search != "" {
+		terms = utils.SearchTerms(c.Search)
+	}
+
+	board := make([]BoardColumn, len(columns))
+	for i, label := range columns {
+		board[i] = BoardColumn{Label: label, Messages: []Message{}}
+
+		var counts []struct {
+			Count int `bson:"count"`
+		}
+		if err := result[fmt.Sprintf("count%d", i)].Unmarshal(&counts); err != nil {
+			return nil, err
+		}
+		if len(counts) > 0 {
+			board[i].Count = counts[0].Count
+		}
+
+		if err := result[fmt.Sprintf("messages%d", i)].Unmarshal(&board[i].Messages); err != nil {
+			return nil, err
+		}
+		if len(terms) > 0 {
+			for j := range board[i].Messages {
+				board[i].Messages[j].Highlights = utils.Highlight(board[i].Messages[j].Text, terms)
+			}
+		}
+	}
+	return board, nil
+}
+
+// boardColumns returns labels of message which are columns
+func boardColumns(message Message, columns []string) []string {
+	in := []string{}
+	for _, l := range message.Labels {
+		if utils.ArrayContains(columns, l.Text) {
+			in = append(in, l.Text)
+		}
+	}
+	return in
+}
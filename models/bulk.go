@@ -0,0 +1,92 @@
+package models
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/ovh/tat/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MaxBulkMessages is the max number of messages inserted by one InsertBulk
+const MaxBulkMessages = 500
+
+// BulkMessage is a message to insert with InsertBulk
+type BulkMessage struct {
+	Topic        Topic
+	Text         string
+	InReplyOfID  string
+	Reference    *Message // message replied, loaded from InReplyOfID if nil
+	DateCreation int64
+	Labels       []Label
+	ExternalKey  string
+	Attributes   Attributes
+	Message      Message // message inserted
+	Err          error   // nil if message is inserted
+}
+
+// InsertBulk inserts messages of user with one bulk insert.
+// A message with Err already set is skipped, Err is set on each
+// message rejected by checks or not inserted.
+// If bulk insert fails, messages not inserted are inserted one by one
+func InsertBulk(user User, messages []*BulkMessage) {
+	var docs []interface{}
+	var prepared []*BulkMessage
+	for _, m := range messages {
+		if m.Err != nil {
+			continue
+		}
+		m.Message.ExternalKey = m.ExternalKey
+		m.Message.Attributes = m.Attributes
+		m.Err = m.Message.prepare(user, m.Topic, m.Text, m.InReplyOfID, m.DateCreation, m.Labels, false, m.Reference)
+		if m.Err == nil {
+			docs = append(docs, &m.Message)
+			prepared = append(prepared, m)
+		}
+	}
+	if len(docs) == 0 {
+		return
+	}
+
+	b := Store().clMessages.Bulk()
+	b.Unordered()
+	b.Insert(docs...)
+	if _, err := b.Run(); err != nil {
+		log.Warnf("Error while inserting %d messages in bulk, insert them one by one: %s", len(docs), err)
+		insertBulkFallback(prepared)
+	}
+
+	for _, m := range prepared {
+		if m.Err == nil {
+			m.Message.afterInsert(user, m.Topic)
+		}
+	}
+}
+
+// insertBulkFallback inserts one by one messages not inserted by a failed bulk
+func insertBulkFallback(prepared []*BulkMessage) {
+	var ids, inserted []string
+	for _, m := range prepared {
+		ids = append(ids, m.Message.ID)
+	}
+	err := Store().clMessages.Find(bson.M{"_id": bson.M{"$in": ids}}).Distinct("_id", &inserted)
+	if err != nil {
+		log.Errorf("Error while getting messages inserted in bulk: %s", err)
+	}
+
+	for _, m := range prepared {
+		if utils.ArrayContains(inserted, m.Message.ID) {
+			continue
+		}
+		err := Store().clMessages.Insert(&m.Message)
+		if err == nil {
+			continue
+		}
+		// a duplicate id is a message inserted by bulk, not yet read
+		if n, errCount := Store().clMessages.FindId(m.Message.ID).Count(); mgo.IsDup(err) && errCount == nil && n > 0 {
+			continue
+		}
+		if m.Err = m.Message.duplicateExternalKey(err); m.Err == err {
+			log.Errorf("Error while inserting new message %s", err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ovh/tat/utils"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// TopicLabel struct, a label of labels catalog of a topic.
+// Labels with same Group are exclusive on a message, as todo, doing and done
+type TopicLabel struct {
+	Text        string `bson:"text"        json:"text"`
+	Color       string `bson:"color"       json:"color"`
+	Description string `bson:"description" json:"description,omitempty"`
+	Group       string `bson:"group"       json:"group,omitempty"`
+}
+
+// a color is #14892c, #fff or rgba(143,199,148,0.61)
+var labelColorRegexp = regexp.MustCompile(`^(#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})|rgba?\([0-9., ]+\))$`)
+
+// SetLabelsCatalog replaces labels catalog of topic. With strictLabels,
+// only labels of catalog can be added on messages of topic
+func (topic *Topic) SetLabelsCatalog(username string, recursive bool, labels []TopicLabel, strictLabels bool) error {
+	var texts []string
+	for _, l := range labels {
+		if l.Text == "" || len(l.Text) > lengthLabel {
+			return fmt.Errorf("Invalid label %s, text of a label is %d characters max and can't be empty", l.Text, lengthLabel)
+		}
+		if utils.ArrayContains(texts, l.Text) {
+			return fmt.Errorf("Invalid labels catalog, label %s is defined twice", l.Text)
+		}
+		if !labelColorRegexp.MatchString(l.Color) {
+			return fmt.Errorf("Invalid color %s for label %s, expected format is #14892c or rgba(143,199,148,0.61)", l.Color, l.Text)
+		}
+		texts = append(texts, l.Text)
+	}
+	if labels == nil {
+		labels = []TopicLabel{}
+	}
+
+	var selector bson.M
+	if recursive {
+		selector = bson.M{"topic": bson.RegEx{Pattern: "^" + topic.Topic + ".*$"}}
+	} else {
+		selector = bson.M{"_id": topic.ID}
+	}
+
+	_, err := Store().clTopics.UpdateAll(selector, bson.M{"$set": bson.M{
+		"labelsCatalog": labels,
+		"strictLabels":  strictLabels,
+	}})
+	if err != nil {
+		log.Errorf("Error while updateAll labels catalog : %s", err.Error())
+		return err
+	}
+	topic.LabelsCatalog = labels
+	topic.StrictLabels = strictLabels
+	h := fmt.Sprintf("update labels catalog to %d labels, strictLabels:%t", len(labels), strictLabels)
+	return topic.addToHistory(selector, username, h)
+}
+
+// catalogLabel returns label with text in labels catalog of topic
+func (topic *Topic) catalogLabel(text string) (TopicLabel, bool) {
+	for _, l := range topic.LabelsCatalog {
+		if l.Text == text {
+			return l, true
+		}
+	}
+	return TopicLabel{}, false
+}
+
+// CheckCatalogLabels returns labels with their color in labels catalog of topic.
+// An error is returned if a label is not in catalog of a topic with strictLabels,
+// or if two labels of a same group are given
+func (topic *Topic) CheckCatalogLabels(labels []Label) ([]Label, error) {
+	var checked []Label
+	groups := make(map[string]string)
+	for _, l := range labels {
+		c, ok := topic.catalogLabel(l.Text)
+		if !ok {
+			if topic.StrictLabels {
+				return nil, fmt.Errorf("Label %s is not in labels catalog of topic %s", l.Text, topic.Topic)
+			}
+			checked = append(checked, l)
+			continue
+		}
+		if other, ok := groups[c.Group]; ok && c.Group != "" && other != c.Text {
+			return nil, fmt.Errorf("Labels %s and %s can't be together on a message, they are in group %s", other, c.Text, c.Group)
+		}
+		groups[c.Group] = c.Text
+		checked = append(checked, Label{Text: c.Text, Color: c.Color})
+	}
+	return checked, nil
+}
+
+// AddLabelOnTopic adds a label to a message, with its color in labels catalog
+// of topic. Labels of message in same group as label are removed, and returned
+func (message *Message) AddLabelOnTopic(topic Topic, label, color string) (Label, []Label, error) {
+	if len(label) > lengthLabel {
+		label = label[0:lengthLabel]
+	}
+	if message.ContainsLabel(label) {
+		return Label{}, nil, fmt.Errorf("AddLabel not possible, %s is already a label of this message", label)
+	}
+	labels, err := topic.CheckCatalogLabels([]Label{Label{Text: label, Color: color}})
+	if err != nil {
+		return Label{}, nil, err
+	}
+
+	var removed []Label
+	if c, ok := topic.catalogLabel(label); ok && c.Group != "" {
+		for _, l := range message.Labels {
+			if other, ok := topic.catalogLabel(l.Text); ok && other.Group == c.Group {
+				removed = append(removed, l)
+			}
+		}
+	}
+	for _, l := range removed {
+		if err := message.RemoveLabel(l.Text); err != nil {
+			return Label{}, removed, err
+		}
+	}
+
+	added, err := message.AddLabel(labels[0].Text, labels[0].Color)
+	return added, removed, err
+}
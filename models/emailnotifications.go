@@ -0,0 +1,228 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ovh/tat/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// EmailNotificationsOff : no email of notifications
+	EmailNotificationsOff = "off"
+	// EmailNotificationsImmediate : one email by notification
+	EmailNotificationsImmediate = "immediate"
+	// EmailNotificationsHourly : one digest of unread notifications by hour
+	EmailNotificationsHourly = "hourly"
+	// EmailNotificationsDaily : one digest of unread notifications by day
+	EmailNotificationsDaily = "daily"
+)
+
+// MaxNotificationsByEmail is the max number of notifications in one email
+const MaxNotificationsByEmail = 50
+
+// emailNotificationsPeriods are min seconds between two emails, by mode
+var emailNotificationsPeriods = map[string]int64{
+	EmailNotificationsImmediate: 0,
+	EmailNotificationsHourly:    3600,
+	EmailNotificationsDaily:     86400,
+}
+
+// notificationRegexp parses text of a notification written by insertNotification
+var notificationRegexp = regexp.MustCompile(`(?s)^#mention #idMessage:(\S+) #topic:(\S+) (.*)$`)
+
+// EmailNotifications struct, preferences of user for emails of notifications.
+// Notifications created after DateLastSent are sent on next email
+type EmailNotifications struct {
+	Mode         string `bson:"mode"         json:"mode"`
+	DateLastSent int64  `bson:"dateLastSent" json:"dateLastSent,omitempty"`
+	Token        string `bson:"token"        json:"-"`
+}
+
+// IsValidEmailNotificationsMode returns true if mode is off, immediate, hourly or daily
+func IsValidEmailNotificationsMode(mode string) bool {
+	_, ok := emailNotificationsPeriods[mode]
+	return ok || mode == EmailNotificationsOff
+}
+
+// SetEmailNotifications updates mode of emails of notifications of user.
+// Notifications created before are not sent
+func (user *User) SetEmailNotifications(mode string) error {
+	if !IsValidEmailNotificationsMode(mode) {
+		return fmt.Errorf("Invalid mode %s, mode is off, immediate, hourly or daily", mode)
+	}
+	var token string
+	if user.EmailNotifications != nil {
+		token = user.EmailNotifications.Token
+	}
+	if token == "" {
+		var err error
+		if token, err = utils.GenerateSalt(); err != nil {
+			return err
+		}
+	}
+
+	emailNotifications := &EmailNotifications{Mode: mode, DateLastSent: time.Now().Unix(), Token: token}
+	err := Store().clUsers.Update(
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"emailNotifications": emailNotifications}})
+	if err != nil {
+		log.Errorf("Error while updating email notifications of user %s: %s", user.Username, err)
+		return err
+	}
+	user.EmailNotifications = emailNotifications
+	return nil
+}
+
+// UnsubscribeEmailNotifications disables emails of notifications of user
+// username, token is given in unsubscribe link of emails
+func UnsubscribeEmailNotifications(username, token string) error {
+	if token == "" {
+		return fmt.Errorf("Invalid unsubscribe link")
+	}
+	err := Store().clUsers.Update(
+		bson.M{"username": username, "emailNotifications.token": token},
+		bson.M{"$set": bson.M{"emailNotifications.mode": EmailNotificationsOff}})
+	if err == mgo.ErrNotFound {
+		return fmt.Errorf("Invalid unsubscribe link")
+	}
+	return err
+}
+
+// SendEmailNotifications sends emails of unread notifications, every period seconds.
+// Each user is claimed before sending, so that many tat instances can run it
+func SendEmailNotifications(period int) {
+	if period <= 0 {
+		log.Warnf("Emails of notifications are disabled")
+		return
+	}
+	for range time.Tick(time.Duration(period) * time.Second) {
+		for {
+			user, dateLastSent, err := claimEmailNotifications()
+			if err == mgo.ErrNotFound {
+				break
+			} else if err != nil {
+				log.Errorf("Error while claiming a user to send notifications: %s", err)
+				break
+			}
+			if dateSent, err := user.sendEmailNotifications(dateLastSent); err != nil {
+				log.Errorf("Error while sending notifications to %s: %s", user.Username, err)
+				// notifications not sent are sent on next period
+				Store().clUsers.Update(
+					bson.M{"_id": user.ID, "emailNotifications.dateLastSent": user.EmailNotifications.DateLastSent},
+					bson.M{"$set": bson.M{"emailNotifications.dateLastSent": dateSent}})
+			}
+		}
+	}
+}
+
+// claimEmailNotifications returns a user with an email to send, and previous date of email
+func claimEmailNotifications() (User, int64, error) {
+	var user, previous User
+	now := time.Now().Unix()
+	var modes []bson.M
+	for mode, period := range emailNotificationsPeriods {
+		modes = append(modes, bson.M{
+			"emailNotifications.mode":         mode,
+			"emailNotifications.dateLastSent": bson.M{"$lte": now - period - 1},
+		})
+	}
+	_, err := Store().clUsers.Find(bson.M{"$or": modes, "isArchived": false}).
+		Select(user.getFieldsExceptAuth()).
+		Apply(mgo.Change{
+			Update:    bson.M{"$set": bson.M{"emailNotifications.dateLastSent": now}},
+			ReturnNew: false,
+		}, &previous)
+	if err != nil {
+		return user, 0, err
+	}
+	dateLastSent := previous.EmailNotifications.DateLastSent
+	user = previous
+	user.EmailNotifications = &EmailNotifications{Mode: previous.EmailNotifications.Mode, DateLastSent: now, Token: previous.EmailNotifications.Token}
+	return user, dateLastSent, nil
+}
+
+// sendEmailNotifications sends unread notifications of user created after
+// dateMin, and not read: one email by notification, or one digest. On error,
+// notifications created until returned date were sent
+func (user *User) sendEmailNotifications(dateMin int64) (int64, error) {
+	if dateRead := user.notificationsDateRead(); dateRead > dateMin {
+		dateMin = dateRead
+	}
+	selector := user.unreadNotificationsSelector()
+	selector["dateCreation"] = bson.M{"$gt": dateMin, "$lte": user.EmailNotifications.DateLastSent}
+	if user.EmailNotifications.Mode == EmailNotificationsImmediate {
+		return user.sendEmailNotificationsImmediate(selector, dateMin)
+	}
+
+	count, err := Store().clMessages.Find(selector).Count()
+	if err != nil || count == 0 {
+		return dateMin, err
+	}
+	var notifications []Message
+	err = Store().clMessages.Find(selector).Sort("-dateCreation").Limit(MaxNotificationsByEmail).All(&notifications)
+	if err != nil {
+		return dateMin, err
+	}
+
+	data := user.notificationEmail(count)
+	for _, n := range notifications {
+		data.Notifications = append(data.Notifications, notificationEmailItem(n))
+	}
+	subject := fmt.Sprintf("Tat: %d unread notifications", count)
+	return dateMin, utils.SendNotificationEmail(user.Email, subject, true, data)
+}
+
+// sendEmailNotificationsImmediate sends one email by notification matching selector,
+// oldest first. On error, returns the date until which all notifications were sent
+func (user *User) sendEmailNotificationsImmediate(selector bson.M, dateMin int64) (int64, error) {
+	// dateSent is the date until which all notifications were sent,
+	// dateLast is the date of last notification sent
+	dateSent, dateLast := dateMin, dateMin
+	var notification Message
+	iter := Store().clMessages.Find(selector).Sort("dateCreation").Iter()
+	for iter.Next(&notification) {
+		if notification.DateCreation > dateLast {
+			dateSent = dateLast
+		}
+		item := notificationEmailItem(notification)
+		data := user.notificationEmail(1)
+		data.Notifications = []utils.NotificationEmailItem{item}
+		subject := fmt.Sprintf("Tat: %s mentioned you on %s", item.Author, item.Topic)
+		if err := utils.SendNotificationEmail(user.Email, subject, false, data); err != nil {
+			iter.Close()
+			return dateSent, err
+		}
+		dateLast = notification.DateCreation
+	}
+	if err := iter.Close(); err != nil {
+		return dateSent, err
+	}
+	return dateLast, nil
+}
+
+// notificationEmail returns data of an email of count notifications, without notifications
+func (user *User) notificationEmail(count int) utils.NotificationEmail {
+	return utils.NotificationEmail{
+		Username:       user.Username,
+		Count:          count,
+		UnsubscribeURL: utils.GetUnsubscribeURL(user.Username, user.EmailNotifications.Token),
+	}
+}
+
+// notificationEmailItem returns message, topic and text of a notification
+func notificationEmailItem(notification Message) utils.NotificationEmailItem {
+	item := utils.NotificationEmailItem{
+		Author: notification.Author.Username,
+		Text:   notification.Text,
+		Date:   time.Unix(notification.DateCreation, 0).UTC().Format("2006-01-02 15:04 MST"),
+	}
+	if m := notificationRegexp.FindStringSubmatch(notification.Text); m != nil {
+		item.IDMessage, item.Topic, item.Text = m[1], m[2], m[3]
+	}
+	return item
+}
@@ -0,0 +1,238 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// ExportTypeTopic is type of a line of export containing a topic
+	ExportTypeTopic = "topic"
+	// ExportTypeMessage is type of a line of export containing a message
+	ExportTypeMessage = "message"
+)
+
+// ExportLine is a line of an export of topics, as JSON Lines:
+// topics first, parents before sub-topics, then messages, roots before replies
+type ExportLine struct {
+	Type    string   `json:"type"`
+	Topic   *Topic   `json:"topic,omitempty"`
+	Message *Message `json:"message,omitempty"`
+}
+
+// ImportOptions are options of ImportTopics
+type ImportOptions struct {
+	Remap      bool   // new ids for topics and messages, instead of ids of export
+	FromPrefix string // topics starting with FromPrefix are renamed with ToPrefix
+	ToPrefix   string
+}
+
+// ExportResult contains numbers of topics and messages exported or imported
+type ExportResult struct {
+	Topics   int `json:"topics"`
+	Messages int `json:"messages"`
+}
+
+// topicSubtreeRegex returns regex of topic and its sub-topics
+func topicSubtreeRegex(topic string) bson.RegEx {
+	return bson.RegEx{Pattern: "^" + regexp.QuoteMeta(topic) + "(/|$)"}
+}
+
+// ExportTopics writes topic, its sub-topics and all their messages to w, as JSON Lines
+func ExportTopics(topicName string, w io.Writer) (ExportResult, error) {
+	var result ExportResult
+	encoder := json.NewEncoder(w)
+
+	var topics []Topic
+	err := Store().clTopics.Find(bson.M{"topic": topicSubtreeRegex(topicName)}).Sort("topic").All(&topics)
+	if err != nil {
+		return result, err
+	}
+	if len(topics) == 0 {
+		return result, fmt.Errorf("Topic %s does not exist", topicName)
+	}
+
+	var names []string
+	for i := range topics {
+		if err := encoder.Encode(&ExportLine{Type: ExportTypeTopic, Topic: &topics[i]}); err != nil {
+			return result, err
+		}
+		names = append(names, topics[i].Topic)
+		result.Topics++
+	}
+
+	var message Message
+	iter := Store().clMessages.Find(bson.M{"topics": bson.M{"$in": names}}).Sort("dateCreation", "_id").Iter()
+	for iter.Next(&message) {
+		if err := encoder.Encode(&ExportLine{Type: ExportTypeMessage, Message: &message}); err != nil {
+			iter.Close()
+			return result, err
+		}
+		result.Messages++
+		message = Message{}
+	}
+	return result, iter.Close()
+}
+
+// ImportTopics reads topics and messages exported by ExportTopics from r, and inserts them.
+// A topic must not exist, its parent must exist or be imported before it. Ids of
+// messages are kept, or remapped with options.Remap: replies and pinned messages
+// follow their new ids. Topics are inserted on a first pass, with all new ids of
+// messages, and messages on a second pass: a reply is remapped even if it is
+// before its root. Import stops on first error, lines before are imported
+func ImportTopics(r io.Reader, username string, options ImportOptions) (ExportResult, error) {
+	var result ExportResult
+	decoder := json.NewDecoder(r)
+	ids := make(map[string]string)
+	var pinned []Topic
+
+	// messages are kept in a temporary file for second pass
+	tmp, err := ioutil.TempFile("", "tat-import")
+	if err != nil {
+		log.Errorf("Error while creating temporary file of import: %s", err)
+		return result, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	encoder := json.NewEncoder(tmp)
+	var lines []int
+
+	for line := 1; ; line++ {
+		var l ExportLine
+		err := decoder.Decode(&l)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return result, fmt.Errorf("Invalid line %d: %s", line, err)
+		}
+
+		switch {
+		case l.Type == ExportTypeTopic && l.Topic != nil:
+			err = importTopic(l.Topic, username, options)
+			if err == nil && len(l.Topic.Pinned) > 0 {
+				pinned = append(pinned, *l.Topic)
+			}
+			result.Topics++
+		case l.Type == ExportTypeMessage && l.Message != nil:
+			if l.Message.ID != "" {
+				ids[l.Message.ID] = l.Message.ID
+				if options.Remap {
+					ids[l.Message.ID] = bson.NewObjectId().Hex()
+				}
+			}
+			lines = append(lines, line)
+			err = encoder.Encode(l.Message)
+		default:
+			err = fmt.Errorf("unknown type %s", l.Type)
+		}
+		if err != nil {
+			return result, fmt.Errorf("Error on line %d: %s", line, err)
+		}
+	}
+
+	if _, err := tmp.Seek(0, 0); err != nil {
+		return result, err
+	}
+	decoder = json.NewDecoder(tmp)
+	for _, line := range lines {
+		var message Message
+		if err := decoder.Decode(&message); err != nil {
+			return result, fmt.Errorf("Error on line %d: %s", line, err)
+		}
+		if err := importMessage(&message, ids, options); err != nil {
+			return result, fmt.Errorf("Error on line %d: %s", line, err)
+		}
+		result.Messages++
+	}
+
+	// pinned messages are imported after their topic
+	for _, topic := range pinned {
+		var newPinned []string
+		for _, id := range topic.Pinned {
+			if newID, ok := ids[id]; ok {
+				newPinned = append(newPinned, newID)
+			}
+		}
+		if err := Store().clTopics.Update(bson.M{"_id": topic.ID}, bson.M{"$set": bson.M{"pinned": newPinned}}); err != nil {
+			log.Errorf("Error while importing pinned messages of topic %s: %s", topic.Topic, err)
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// renameTopic replaces options.FromPrefix by options.ToPrefix on topic and its sub-topics
+func renameTopic(topic string, options ImportOptions) string {
+	if options.FromPrefix == "" || (topic != options.FromPrefix && !strings.HasPrefix(topic, options.FromPrefix+"/")) {
+		return topic
+	}
+	return options.ToPrefix + strings.TrimPrefix(topic, options.FromPrefix)
+}
+
+func importTopic(topic *Topic, username string, options ImportOptions) error {
+	topic.Topic = renameTopic(topic.Topic, options)
+	if err := topic.CheckAndFixName(); err != nil {
+		return err
+	}
+	if IsTopicExists(topic.Topic) {
+		return fmt.Errorf("Topic %s already exists", topic.Topic)
+	}
+	isRoot, _, err := topic.getParentTopic()
+	if !isRoot && err != nil {
+		return fmt.Errorf("Parent topic of %s does not exist", topic.Topic)
+	}
+
+	if options.Remap || topic.ID == "" {
+		topic.ID = bson.NewObjectId().Hex()
+	}
+	// pinned messages are set at the end of import
+	pinned := topic.Pinned
+	topic.Pinned = nil
+	topic.DateLastPurge = 0
+	if err := Store().clTopics.Insert(topic); err != nil {
+		if mgo.IsDup(err) {
+			return fmt.Errorf("Topic with id %s already exists, use remap", topic.ID)
+		}
+		return err
+	}
+	topic.Pinned = pinned
+	return topic.addToHistory(bson.M{"_id": topic.ID}, username, "import topic")
+}
+
+// importMessage inserts message with its new id in ids, given on first pass of import
+func importMessage(message *Message, ids map[string]string, options ImportOptions) error {
+	if newID, ok := ids[message.ID]; ok {
+		message.ID = newID
+	} else {
+		message.ID = bson.NewObjectId().Hex()
+	}
+
+	// a reply to a message not in export keeps its reference
+	if newID, ok := ids[message.InReplyOfID]; ok {
+		message.InReplyOfID = newID
+	}
+	if newID, ok := ids[message.InReplyOfIDRoot]; ok {
+		message.InReplyOfIDRoot = newID
+	}
+	for i, topic := range message.Topics {
+		message.Topics[i] = renameTopic(topic, options)
+	}
+	message.Replies = nil
+
+	if err := Store().clMessages.Insert(message); err != nil {
+		if mgo.IsDup(err) {
+			return fmt.Errorf("Message with id %s already exists, use remap", message.ID)
+		}
+		return err
+	}
+	return nil
+}
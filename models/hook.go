@@ -0,0 +1,393 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ovh/tat/utils"
+	"github.com/spf13/viper"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// HookEventCreate : a message is created on topic
+	HookEventCreate = "create"
+	// HookEventReply : a reply is created on topic
+	HookEventReply = "reply"
+	// HookEventLabel : a label is added on a message
+	HookEventLabel = "label"
+	// HookEventUnlabel : a label is removed from a message
+	HookEventUnlabel = "unlabel"
+	// HookEventLike : a message is liked
+	HookEventLike = "like"
+	// HookEventDelete : a message is deleted
+	HookEventDelete = "delete"
+	// HookEventMove : a message is moved from or to topic
+	HookEventMove = "move"
+)
+
+var hookEvents = []string{HookEventCreate, HookEventReply, HookEventLabel, HookEventUnlabel, HookEventLike, HookEventDelete, HookEventMove}
+
+const (
+	// HookDeliveryPending : delivery is not done yet, next attempt is pending
+	HookDeliveryPending = "pending"
+	// HookDeliverySuccess : webhook answered with a 2xx status
+	HookDeliverySuccess = "success"
+	// HookDeliveryFailed : all attempts failed
+	HookDeliveryFailed = "failed"
+)
+
+// HookDeliveriesMaxAge is duration of deliveries log, older deliveries are removed
+const HookDeliveriesMaxAge = 7 * 24 * time.Hour
+
+// a delivery claimed for an attempt is claimed again after this delay,
+// if instance delivering it has stopped
+const hookDeliveryClaimTimeout = 300
+
+// Hook struct, an outgoing webhook on a topic: events on messages of topic
+// are posted to URL, signed with Secret. If Filter is not nil, only messages
+// matching Filter trigger webhook
+type Hook struct {
+	ID               string           `bson:"_id"              json:"_id"`
+	Topic            string           `bson:"topic"            json:"topic"`
+	URL              string           `bson:"url"              json:"url"`
+	Secret           string           `bson:"secret"           json:"-"`
+	Events           []string         `bson:"events"           json:"events"`
+	Filter           *MessageCriteria `bson:"filter"           json:"filter,omitempty"`
+	Username         string           `bson:"username"         json:"username"`
+	DateCreation     int64            `bson:"dateCreation"     json:"dateCreation"`
+	DateModification int64            `bson:"dateModification" json:"dateModification"`
+}
+
+// HookDeliveryAttempt struct, an attempt of a delivery, with status code of
+// response, or error. Duration is in milliseconds
+type HookDeliveryAttempt struct {
+	Date       int64  `bson:"date"       json:"date"`
+	StatusCode int    `bson:"statusCode" json:"statusCode,omitempty"`
+	Error      string `bson:"error"      json:"error,omitempty"`
+	Duration   int64  `bson:"duration"   json:"duration"`
+}
+
+// HookDelivery struct, an event posted to a webhook, with all its attempts.
+// A pending delivery is attempted again on NextAttempt
+type HookDelivery struct {
+	ID           string                `bson:"_id"          json:"_id"`
+	IDHook       string                `bson:"idHook"       json:"idHook"`
+	Event        string                `bson:"event"        json:"event"`
+	IDMessage    string                `bson:"idMessage"    json:"idMessage"`
+	Status       string                `bson:"status"       json:"status"`
+	Attempts     []HookDeliveryAttempt `bson:"attempts"     json:"attempts"`
+	DateCreation int64                 `bson:"dateCreation" json:"dateCreation"`
+	NextAttempt  int64                 `bson:"nextAttempt"  json:"nextAttempt,omitempty"`
+	DateClaim    int64                 `bson:"dateClaim"    json:"-"`
+	Body         string                `bson:"body"         json:"-"`
+	Date         time.Time             `bson:"date"         json:"-"` // used by TTL index
+}
+
+// HookPayload is body posted to a webhook
+type HookPayload struct {
+	Event    string  `json:"event"`
+	IDHook   string  `json:"idHook"`
+	Delivery string  `json:"delivery"`
+	Topic    string  `json:"topic"`
+	Username string  `json:"username"`
+	Date     int64   `json:"date"`
+	Message  Message `json:"message"`
+}
+
+// checkAndFix checks url, and its addresses, and events of hook, and removes options
+// of pagination and sort from filter
+func (hook *Hook) checkAndFix() error {
+	if err := utils.CheckWebhookURL(hook.URL); err != nil {
+		return err
+	}
+	if len(hook.Events) == 0 {
+		return fmt.Errorf("Invalid events, at least one event is expected")
+	}
+	for _, event := range hook.Events {
+		if !utils.ArrayContains(hookEvents, event) {
+			return fmt.Errorf("Invalid event %s, events are %v", event, hookEvents)
+		}
+	}
+	if hook.Filter != nil {
+		hook.Filter.Skip, hook.Filter.Limit = 0, 0
+		hook.Filter.TreeView, hook.Filter.SortBy = "", ""
+		hook.Filter.After, hook.Filter.Before = "", ""
+	}
+	return nil
+}
+
+// Insert creates a webhook on topic. A secret is generated if hook has no secret
+func (hook *Hook) Insert(username string, topic Topic) error {
+	if err := hook.checkAndFix(); err != nil {
+		return err
+	}
+	if hook.Secret == "" {
+		secret, err := utils.GenerateSalt()
+		if err != nil {
+			return err
+		}
+		hook.Secret = secret
+	}
+
+	now := time.Now().Unix()
+	hook.ID = bson.NewObjectId().Hex()
+	hook.Topic = topic.Topic
+	hook.Username = username
+	hook.DateCreation = now
+	hook.DateModification = now
+	if err := Store().clHooks.Insert(hook); err != nil {
+		log.Errorf("Error while inserting webhook on topic %s: %s", topic.Topic, err)
+		return err
+	}
+	return topic.addToHistory(bson.M{"_id": topic.ID}, username, fmt.Sprintf("add webhook %s to %s", hook.ID, hook.URL))
+}
+
+// Update replaces url, events and filter of hook, and secret if not empty
+func (hook *Hook) Update(username string, topic Topic, newHook Hook) error {
+	hook.URL = newHook.URL
+	hook.Events = newHook.Events
+	hook.Filter = newHook.Filter
+	if newHook.Secret != "" {
+		hook.Secret = newHook.Secret
+	}
+	if err := hook.checkAndFix(); err != nil {
+		return err
+	}
+	hook.DateModification = time.Now().Unix()
+	if err := Store().clHooks.UpdateId(hook.ID, hook); err != nil {
+		log.Errorf("Error while updating webhook %s: %s", hook.ID, err)
+		return err
+	}
+	return topic.addToHistory(bson.M{"_id": topic.ID}, username, fmt.Sprintf("update webhook %s to %s", hook.ID, hook.URL))
+}
+
+// Delete removes hook and its deliveries
+func (hook *Hook) Delete(username string, topic Topic) error {
+	if err := Store().clHooks.RemoveId(hook.ID); err != nil {
+		log.Errorf("Error while deleting webhook %s: %s", hook.ID, err)
+		return err
+	}
+	if _, err := Store().clHookDeliveries.RemoveAll(bson.M{"idHook": hook.ID}); err != nil {
+		log.Errorf("Error while deleting deliveries of webhook %s: %s", hook.ID, err)
+	}
+	return topic.addToHistory(bson.M{"_id": topic.ID}, username, fmt.Sprintf("delete webhook %s to %s", hook.ID, hook.URL))
+}
+
+// FindByID returns hook by given ID
+func (hook *Hook) FindByID(id string) error {
+	return Store().clHooks.FindId(id).One(hook)
+}
+
+// ListHooks returns webhooks of topic
+func ListHooks(topic string) ([]Hook, error) {
+	var hooks []Hook
+	err := Store().clHooks.Find(bson.M{"topic": topic}).Sort("dateCreation").All(&hooks)
+	if err != nil {
+		log.Errorf("Error while listing webhooks of topic %s: %s", topic, err)
+	}
+	return hooks, err
+}
+
+// removeHooksOfTopic removes webhooks of a deleted topic, and their deliveries
+func removeHooksOfTopic(topic string) {
+	var ids []string
+	if err := Store().clHooks.Find(bson.M{"topic": topic}).Distinct("_id", &ids); err != nil || len(ids) == 0 {
+		return
+	}
+	Store().clHookDeliveries.RemoveAll(bson.M{"idHook": bson.M{"$in": ids}})
+	Store().clHooks.RemoveAll(bson.M{"topic": topic})
+}
+
+// ListDeliveries returns deliveries of hook, newest first
+func (hook *Hook) ListDeliveries(skip, limit int) ([]HookDelivery, error) {
+	var deliveries []HookDelivery
+	err := Store().clHookDeliveries.Find(bson.M{"idHook": hook.ID}).
+		Sort("-dateCreation", "-_id").
+		Skip(skip).
+		Limit(limit).
+		All(&deliveries)
+	if err != nil {
+		log.Errorf("Error while listing deliveries of webhook %s: %s", hook.ID, err)
+	}
+	return deliveries, err
+}
+
+// hookEvent returns event of webhooks for an action on a message, "" if
+// action does not trigger webhooks
+func hookEvent(msg *WSMessageJSON) string {
+	if msg.Action == HookEventCreate && msg.Message.InReplyOfID != "" {
+		return HookEventReply
+	}
+	// a like is a reaction like
+	if msg.Action == "react" && msg.Reaction == ReactionLike {
+		return HookEventLike
+	}
+	if utils.ArrayContains(hookEvents, msg.Action) {
+		return msg.Action
+	}
+	return ""
+}
+
+// triggerHooks delivers an action on a message to webhooks of topics of message
+// listening to its event. Delivery is asynchronous
+func triggerHooks(msg *WSMessageJSON) {
+	event := hookEvent(msg)
+	if event == "" {
+		return
+	}
+	var hooks []Hook
+	err := Store().clHooks.Find(bson.M{"topic": bson.M{"$in": wsTopics(msg)}, "events": event}).All(&hooks)
+	if err != nil {
+		log.Errorf("Error while getting webhooks of message %s: %s", msg.Message.ID, err)
+		return
+	}
+	for i := range hooks {
+		go hooks[i].deliver(event, msg.Username, msg.Message)
+	}
+}
+
+// matchFilter returns true if message matches filter of hook. A deleted
+// message can't be checked, filter is ignored on event delete
+func (hook *Hook) matchFilter(event string, message Message) bool {
+	if hook.Filter == nil || event == HookEventDelete {
+		return true
+	}
+	criteria := *hook.Filter
+	selector := bson.M{"$and": []bson.M{bson.M{"_id": message.ID}, buildMessageCriteria(&criteria)}}
+	n, err := Store().clMessages.Find(selector).Count()
+	if err != nil {
+		log.Errorf("Error while checking filter of webhook %s: %s", hook.ID, err)
+		return false
+	}
+	return n > 0
+}
+
+// deliver posts event to hook. Delivery is stored before its first attempt,
+// claimed by this instance, failed attempts are retried by DeliverHooks
+func (hook *Hook) deliver(event, username string, message Message) {
+	if !hook.matchFilter(event, message) {
+		return
+	}
+
+	now := time.Now()
+	delivery := HookDelivery{
+		ID:           bson.NewObjectId().Hex(),
+		IDHook:       hook.ID,
+		Event:        event,
+		IDMessage:    message.ID,
+		Status:       HookDeliveryPending,
+		Attempts:     []HookDeliveryAttempt{},
+		DateCreation: now.Unix(),
+		NextAttempt:  now.Unix(),
+		DateClaim:    now.Unix(),
+		Date:         now,
+	}
+	body, err := json.Marshal(&HookPayload{
+		Event:    event,
+		IDHook:   hook.ID,
+		Delivery: delivery.ID,
+		Topic:    hook.Topic,
+		Username: username,
+		Date:     now.Unix(),
+		Message:  message,
+	})
+	if err != nil {
+		log.Errorf("Error while marshalling event of webhook %s: %s", hook.ID, err)
+		return
+	}
+	delivery.Body = string(body)
+	if err := Store().clHookDeliveries.Insert(&delivery); err != nil {
+		log.Errorf("Error while inserting delivery of webhook %s: %s", hook.ID, err)
+		return
+	}
+	delivery.attempt(hook)
+}
+
+// attempt posts delivery to hook, and logs attempt on delivery. After a failed
+// attempt, NextAttempt is set with a backoff, until all retries are done
+func (delivery *HookDelivery) attempt(hook *Hook) {
+	headers := map[string]string{"X-Tat-Event": delivery.Event, "X-Tat-Delivery": delivery.ID}
+	timeout := time.Duration(viper.GetInt("webhooks_timeout")) * time.Second
+	maxAttempts := viper.GetInt("webhooks_retries") + 1
+	n := len(delivery.Attempts) + 1
+
+	start := time.Now()
+	statusCode, err := utils.PostWebhook(hook.URL, hook.Secret, []byte(delivery.Body), headers, timeout)
+	attempt := HookDeliveryAttempt{
+		Date:       start.Unix(),
+		StatusCode: statusCode,
+		Duration:   int64(time.Since(start) / time.Millisecond),
+	}
+	set := bson.M{"status": HookDeliverySuccess, "dateClaim": 0}
+	if err != nil {
+		attempt.Error = err.Error()
+		if n >= maxAttempts {
+			set["status"] = HookDeliveryFailed
+			log.Warnf("Delivery %s of webhook %s failed after %d attempts: %s", delivery.ID, hook.ID, n, err)
+		} else {
+			set["status"] = HookDeliveryPending
+			set["nextAttempt"] = time.Now().Add(utils.WebhookBackoff(n + 1)).Unix()
+		}
+	}
+
+	errUpdate := Store().clHookDeliveries.UpdateId(delivery.ID, bson.M{
+		"$push": bson.M{"attempts": attempt},
+		"$set":  set,
+	})
+	if errUpdate != nil {
+		log.Errorf("Error while updating delivery %s of webhook %s: %s", delivery.ID, hook.ID, errUpdate)
+	}
+}
+
+// DeliverHooks retries pending deliveries of webhooks, every period seconds.
+// Each delivery is claimed before its attempt, so that many tat instances can run it
+func DeliverHooks(period int) {
+	if period <= 0 {
+		log.Warnf("Retries of deliveries of webhooks are disabled")
+		return
+	}
+	// deliveries pending before retries were stored can't be retried
+	_, err := Store().clHookDeliveries.UpdateAll(
+		bson.M{"status": HookDeliveryPending, "nextAttempt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"status": HookDeliveryFailed}})
+	if err != nil {
+		log.Errorf("Error while setting failed deliveries of webhooks without retry: %s", err)
+	}
+
+	for range time.Tick(time.Duration(period) * time.Second) {
+		for {
+			delivery, err := claimHookDelivery()
+			if err == mgo.ErrNotFound {
+				break
+			} else if err != nil {
+				log.Errorf("Error while claiming a delivery of webhook: %s", err)
+				break
+			}
+			hook := Hook{}
+			if err := hook.FindByID(delivery.IDHook); err != nil {
+				// webhook deleted meanwhile
+				Store().clHookDeliveries.UpdateId(delivery.ID, bson.M{"$set": bson.M{"status": HookDeliveryFailed, "dateClaim": 0}})
+				continue
+			}
+			go delivery.attempt(&hook)
+		}
+	}
+}
+
+func claimHookDelivery() (HookDelivery, error) {
+	var delivery HookDelivery
+	now := time.Now().Unix()
+	_, err := Store().clHookDeliveries.Find(bson.M{
+		"status":      HookDeliveryPending,
+		"nextAttempt": bson.M{"$lte": now},
+		"dateClaim":   bson.M{"$lt": now - hookDeliveryClaimTimeout},
+	}).Sort("nextAttempt").Apply(mgo.Change{
+		Update:    bson.M{"$set": bson.M{"dateClaim": now}},
+		ReturnNew: true,
+	}, &delivery)
+	return delivery, err
+}
@@ -0,0 +1,303 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ovh/tat/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// IncomingHookUsernamePrefix prefixes username of author of messages posted by an incoming webhook
+const IncomingHookUsernamePrefix = "tat.webhook."
+
+// DefaultIncomingHookRateLimit is max number of messages by minute of an incoming webhook, if not given
+const DefaultIncomingHookRateLimit = 60
+
+// IncomingHook struct, an incoming webhook: a secret url posting messages
+// on Topic, without credentials. Only hash of token is stored.
+// With an Adapter, payloads of a tool are converted to messages, with Templates
+type IncomingHook struct {
+	ID           string            `bson:"_id"          json:"_id"`
+	Topic        string            `bson:"topic"        json:"topic"`
+	Name         string            `bson:"name"         json:"name"`
+	TokenHash    string            `bson:"tokenHash"    json:"-"`
+	RateLimit    int               `bson:"rateLimit"    json:"rateLimit"`
+	Username     string            `bson:"username"     json:"username"`
+	DateCreation int64             `bson:"dateCreation" json:"dateCreation"`
+	DateLastUse  int64             `bson:"dateLastUse"  json:"dateLastUse,omitempty"`
+	Adapter      string            `bson:"adapter"      json:"adapter,omitempty"`
+	Templates    map[string]string `bson:"templates"    json:"templates,omitempty"`
+}
+
+// IncomingMessage is a message posted on an incoming webhook: Text, with optional
+// labels and tags, or a reply to IDReference
+type IncomingMessage struct {
+	Text        string   `json:"text"`
+	Labels      []Label  `json:"labels"`
+	Tags        []string `json:"tags"`
+	IDReference string   `json:"idReference"`
+}
+
+// Insert creates an incoming webhook on topic, and returns its token.
+// Token is not stored, only its hash
+func (hook *IncomingHook) Insert(username string, topic Topic) (string, error) {
+	if hook.RateLimit < 0 {
+		return "", fmt.Errorf("Invalid rateLimit %d, rateLimit must be positive", hook.RateLimit)
+	}
+	if hook.RateLimit == 0 {
+		hook.RateLimit = DefaultIncomingHookRateLimit
+	}
+	hook.Name = strings.TrimSpace(hook.Name)
+	if len(hook.Name) > 64 {
+		return "", fmt.Errorf("Invalid name, max length is 64")
+	}
+	if hook.Adapter != "" && !utils.IsValidAdapter(hook.Adapter) {
+		return "", fmt.Errorf("Invalid adapter %s, adapters are %v", hook.Adapter, utils.Adapters)
+	}
+	if len(hook.Templates) > 0 && hook.Adapter == "" {
+		return "", fmt.Errorf("Invalid templates, templates are used only with an adapter")
+	}
+	if err := utils.CheckAdapterTemplates(hook.Templates); err != nil {
+		return "", err
+	}
+
+	token, hash, err := utils.GeneratePassword()
+	if err != nil {
+		return "", err
+	}
+	hook.ID = bson.NewObjectId().Hex()
+	hook.Topic = topic.Topic
+	hook.TokenHash = hash
+	hook.Username = username
+	hook.DateCreation = time.Now().Unix()
+	if err := Store().clIncomingHooks.Insert(hook); err != nil {
+		log.Errorf("Error while inserting incoming webhook on topic %s: %s", topic.Topic, err)
+		return "", err
+	}
+	return token, topic.addToHistory(bson.M{"_id": topic.ID}, username, fmt.Sprintf("add incoming webhook %s", hook.ID))
+}
+
+// Delete revokes hook, its url can't be used anymore
+func (hook *IncomingHook) Delete(username string, topic Topic) error {
+	if err := Store().clIncomingHooks.RemoveId(hook.ID); err != nil {
+		log.Errorf("Error while deleting incoming webhook %s: %s", hook.ID, err)
+		return err
+	}
+	return topic.addToHistory(bson.M{"_id": topic.ID}, username, fmt.Sprintf("delete incoming webhook %s", hook.ID))
+}
+
+// FindByID returns incoming hook by given ID
+func (hook *IncomingHook) FindByID(id string) error {
+	return Store().clIncomingHooks.FindId(id).One(hook)
+}
+
+// FindByToken returns incoming hook by given ID, if token is valid
+func (hook *IncomingHook) FindByToken(id, token string) error {
+	if err := hook.FindByID(id); err != nil {
+		return err
+	}
+	if !utils.IsCheckValid(token, hook.TokenHash) {
+		return fmt.Errorf("Invalid token for incoming webhook %s", id)
+	}
+	return nil
+}
+
+// ListIncomingHooks returns incoming webhooks of topic
+func ListIncomingHooks(topic string) ([]IncomingHook, error) {
+	var hooks []IncomingHook
+	err := Store().clIncomingHooks.Find(bson.M{"topic": topic}).Sort("dateCreation").All(&hooks)
+	if err != nil {
+		log.Errorf("Error while listing incoming webhooks of topic %s: %s", topic, err)
+	}
+	return hooks, err
+}
+
+// removeIncomingHooksOfTopic revokes incoming webhooks of a deleted topic
+func removeIncomingHooksOfTopic(topic string) {
+	Store().clIncomingHooks.RemoveAll(bson.M{"topic": topic})
+}
+
+// Author returns pseudo user author of messages posted by hook
+func (hook *IncomingHook) Author() User {
+	fullname := hook.Name
+	if fullname == "" {
+		fullname = "Webhook"
+	}
+	return User{Username: IncomingHookUsernamePrefix + hook.ID, Fullname: fullname}
+}
+
+// takeTokens takes n tokens in bucket of hook, with RateLimit messages by minute,
+// and in bucket of its topic
+func (hook *IncomingHook) takeTokens(topic Topic, n int) error {
+	author := hook.Author()
+	limits := []rateLimit{{scope: RateLimitScopeHook, username: author.Username, rate: hook.RateLimit}}
+	for _, l := range messageRateLimits(author, topic) {
+		if l.scope == RateLimitScopeTopic {
+			limits = append(limits, l)
+		}
+	}
+	if err := takeTokens(limits, n); err != nil {
+		return err
+	}
+	Store().clIncomingHooks.UpdateId(hook.ID, bson.M{"$set": bson.M{"dateLastUse": time.Now().Unix()}})
+	return nil
+}
+
+// Post inserts a message posted on hook. A reply must be on topic of hook
+func (hook *IncomingHook) Post(in IncomingMessage) (Message, error) {
+	message := Message{}
+	topic := Topic{}
+	if err := topic.FindByTopic(hook.Topic, false); err != nil {
+		return message, fmt.Errorf("Topic %s does not exist", hook.Topic)
+	}
+
+	var messageReference *Message
+	if in.IDReference != "" {
+		messageReference = &Message{}
+		if err := messageReference.FindByID(in.IDReference); err != nil || !utils.ArrayContains(messageReference.Topics, hook.Topic) {
+			return message, fmt.Errorf("Message %s does not exist on topic %s", in.IDReference, hook.Topic)
+		}
+	}
+
+	if err := hook.takeTokens(topic, 1); err != nil {
+		return message, err
+	}
+
+	author := hook.Author()
+	if err := message.prepare(author, topic, in.Text, in.IDReference, -1, in.Labels, false, messageReference); err != nil {
+		return message, err
+	}
+	for _, tag := range in.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" && !message.containsTag(tag) {
+			message.Tags = append(message.Tags, tag)
+		}
+	}
+
+	if err := Store().clMessages.Insert(&message); err != nil {
+		log.Errorf("Error while inserting message of incoming webhook %s: %s", hook.ID, err)
+		return message, err
+	}
+	message.afterInsert(author, topic)
+	return message, nil
+}
+
+// AdapterEvents struct, messages created by events of an adapter, and roots of threads
+// with updated labels. Labeled and Unlabeled contain a root once by label added or removed
+type AdapterEvents struct {
+	Created   []Message
+	Updated   []Message
+	Labeled   []Message
+	Unlabeled []Message
+}
+
+// PostEvents creates or updates threads of events converted by adapter of hook:
+// a new thread is created for a new ExternalKey, else labels of its root are updated,
+// with a reply. An event without change on labels is ignored. A token is taken by event
+func (hook *IncomingHook) PostEvents(events []utils.AdapterEvent) (AdapterEvents, error) {
+	var result AdapterEvents
+	topic := Topic{}
+	if err := topic.FindByTopic(hook.Topic, false); err != nil {
+		return result, fmt.Errorf("Topic %s does not exist", hook.Topic)
+	}
+	if err := hook.takeTokens(topic, len(events)); err != nil {
+		return result, err
+	}
+
+	author := hook.Author()
+	for _, e := range events {
+		root := Message{}
+		err := root.FindByExternalKey(hook.Topic, e.ExternalKey)
+		if err == mgo.ErrNotFound {
+			message, err := hook.createThread(author, topic, e)
+			if errDup, ok := err.(*DuplicateExternalKeyError); ok {
+				// thread created meanwhile by a concurrent post of same event
+				root = errDup.Message
+			} else if err != nil {
+				return result, err
+			} else {
+				result.Created = append(result.Created, message)
+				continue
+			}
+		} else if err != nil {
+			return result, err
+		}
+
+		added, removed := root.updateAdapterLabels(topic, e)
+		if added+removed == 0 {
+			continue
+		}
+		result.Updated = append(result.Updated, root)
+		for i := 0; i < added; i++ {
+			result.Labeled = append(result.Labeled, root)
+		}
+		for i := 0; i < removed; i++ {
+			result.Unlabeled = append(result.Unlabeled, root)
+		}
+
+		reply := Message{}
+		if err := reply.prepare(author, topic, e.Text, root.ID, -1, nil, false, &root); err != nil {
+			return result, err
+		}
+		if err := Store().clMessages.Insert(&reply); err != nil {
+			return result, err
+		}
+		reply.afterInsert(author, topic)
+		result.Created = append(result.Created, reply)
+	}
+	return result, nil
+}
+
+// createThread inserts root message of a thread of an adapter
+func (hook *IncomingHook) createThread(author User, topic Topic, e utils.AdapterEvent) (Message, error) {
+	message := Message{ExternalKey: e.ExternalKey}
+	var labels []Label
+	for _, l := range e.Labels {
+		labels = append(labels, Label{Text: l.Text, Color: l.Color})
+	}
+	if err := message.prepare(author, topic, e.Text, "", -1, labels, false, nil); err != nil {
+		return message, err
+	}
+	for _, tag := range e.Tags {
+		if !message.containsTag(tag) {
+			message.Tags = append(message.Tags, tag)
+		}
+	}
+	if err := Store().clMessages.Insert(&message); err != nil {
+		if errDup := message.duplicateExternalKey(err); errDup != err {
+			return message, errDup
+		}
+		log.Errorf("Error while inserting message of incoming webhook %s: %s", hook.ID, err)
+		return message, err
+	}
+	message.afterInsert(author, topic)
+	return message, nil
+}
+
+// updateAdapterLabels removes labels RemoveLabels of event from message, and adds
+// its Labels. Returns numbers of labels added and removed, with labels of exclusive
+// groups removed by labels added
+func (message *Message) updateAdapterLabels(topic Topic, e utils.AdapterEvent) (int, int) {
+	added, removed := 0, 0
+	for _, l := range e.Labels {
+		if message.ContainsLabel(l.Text) {
+			continue
+		}
+		_, removedLabels, err := message.AddLabelOnTopic(topic, l.Text, l.Color)
+		if err != nil {
+			log.Warnf("Error while adding label %s on message %s: %s", l.Text, message.ID, err)
+			continue
+		}
+		added++
+		removed += len(removedLabels)
+	}
+	for _, text := range e.RemoveLabels {
+		if message.ContainsLabel(text) && message.RemoveLabel(text) == nil {
+			removed++
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/ovh/tat/utils"
+	"github.com/spf13/viper"
+	"github.com/yesnault/hashtag"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// TopicAdminsMention mentions admin users and members of admin groups of topic
+const TopicAdminsMention = "topic-admins"
+
+// extractMentions sets users and groups mentioned in text of message, or by rules
+// of topic, and users to notify: users mentioned, and members of groups mentioned
+// without notifications off on topic. Author is never notified. Number of users
+// notified by a message with groups mentioned is limited by mentions_max_users
+func (message *Message) extractMentions(author User, topic Topic) error {
+	message.UserMentions = nil
+	message.GroupMentions = nil
+	var groups []string
+	var members []string
+
+	for _, name := range append(hashtag.ExtractMentions(message.Text), message.ruleMentions...) {
+		if name == TopicAdminsMention {
+			if !utils.ArrayContains(message.GroupMentions, name) {
+				message.GroupMentions = append(message.GroupMentions, name)
+				members = append(members, topic.AdminUsers...)
+				groups = append(groups, topic.AdminGroups...)
+			}
+			continue
+		}
+
+		var user = User{}
+		if err := user.FindByUsername(name); err == nil {
+			if !utils.ArrayContains(message.UserMentions, user.Username) {
+				message.UserMentions = append(message.UserMentions, user.Username)
+			}
+			continue
+		}
+		if IsGroupnameExists(name) && !utils.ArrayContains(message.GroupMentions, name) {
+			message.GroupMentions = append(message.GroupMentions, name)
+			groups = append(groups, name)
+		}
+	}
+
+	message.notified = nil
+	for _, username := range message.UserMentions {
+		if username != author.Username {
+			message.notified = append(message.notified, username)
+		}
+	}
+	if len(message.GroupMentions) == 0 {
+		return nil
+	}
+
+	if len(groups) > 0 {
+		var groupsUsers []string
+		err := Store().clGroups.Find(bson.M{"name": bson.M{"$in": groups}}).Distinct("users", &groupsUsers)
+		if err != nil {
+			return err
+		}
+		members = append(members, groupsUsers...)
+	}
+
+	var usernames []string
+	err := Store().clUsers.Find(bson.M{
+		"username":               bson.M{"$in": members},
+		"isArchived":             false,
+		"offNotificationsTopics": bson.M{"$ne": topic.Topic},
+	}).Distinct("username", &usernames)
+	if err != nil {
+		return err
+	}
+	for _, username := range usernames {
+		if username != author.Username && !utils.ArrayContains(message.notified, username) {
+			message.notified = append(message.notified, username)
+		}
+	}
+
+	if max := viper.GetInt("mentions_max_users"); max > 0 && len(message.notified) > max {
+		return fmt.Errorf("Too many users mentioned, %d users would be notified and max is %d", len(message.notified), max)
+	}
+	return nil
+}
@@ -1,17 +1,19 @@
 package models
 
 import (
-	"errors"
 	"fmt"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/mvdan/xurls"
 	"github.com/ovh/tat/utils"
 	"github.com/yesnault/hashtag"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -20,6 +22,9 @@ var DefaultMessageMaxSize = 140
 
 const lengthLabel = 100
 
+// SortByRelevance sorts messages by score of full-text search
+const SortByRelevance = "relevance"
+
 // Author struct
 type Author struct {
 	Username string `bson:"username" json:"username"`
@@ -34,21 +39,30 @@ type Label struct {
 
 // Message struc
 type Message struct {
-	ID              string    `bson:"_id"             json:"_id"`
-	Text            string    `bson:"text"            json:"text"`
-	Topics          []string  `bson:"topics"          json:"topics"`
-	InReplyOfID     string    `bson:"inReplyOfID"     json:"inReplyOfID"`
-	InReplyOfIDRoot string    `bson:"inReplyOfIDRoot" json:"inReplyOfIDRoot"`
-	NbLikes         int64     `bson:"nbLikes"         json:"nbLikes"`
-	Labels          []Label   `bson:"labels"          json:"labels,omitempty"`
-	Likers          []string  `bson:"likers"          json:"likers,omitempty"`
-	UserMentions    []string  `bson:"userMentions"    json:"userMentions,omitempty"`
-	Urls            []string  `bson:"urls"            json:"urls,omitempty"`
-	Tags            []string  `bson:"tags"            json:"tags,omitempty"`
-	DateCreation    int64     `bson:"dateCreation"    json:"dateCreation"`
-	DateUpdate      int64     `bson:"dateUpdate"      json:"dateUpdate"`
-	Author          Author    `bson:"author"          json:"author"`
-	Replies         []Message `bson:"-"               json:"replies,omitempty"`
+	ID              string     `bson:"_id"             json:"_id"`
+	Text            string     `bson:"text"            json:"text"`
+	Topics          []string   `bson:"topics"          json:"topics"`
+	InReplyOfID     string     `bson:"inReplyOfID"     json:"inReplyOfID"`
+	InReplyOfIDRoot string     `bson:"inReplyOfIDRoot" json:"inReplyOfIDRoot"`
+	NbLikes         int64      `bson:"nbLikes"         json:"nbLikes"`
+	NbRevisions     int        `bson:"nbRevisions"     json:"nbRevisions"`
+	Labels          []Label    `bson:"labels"          json:"labels,omitempty"`
+	Likers          []string   `bson:"likers"          json:"likers,omitempty"`
+	Reactions       []Reaction `bson:"reactions"       json:"reactions,omitempty"`
+	ExternalKey     string     `bson:"externalKey,omitempty" json:"externalKey,omitempty"`
+	Attributes      Attributes `bson:"attributes"      json:"attributes,omitempty"`
+	UserMentions    []string   `bson:"userMentions"    json:"userMentions,omitempty"`
+	GroupMentions   []string   `bson:"groupMentions"   json:"groupMentions,omitempty"`
+	Urls            []string   `bson:"urls"            json:"urls,omitempty"`
+	Tags            []string   `bson:"tags"            json:"tags,omitempty"`
+	DateCreation    int64      `bson:"dateCreation"    json:"dateCreation"`
+	DateUpdate      int64      `bson:"dateUpdate"      json:"dateUpdate"`
+	Author          Author     `bson:"author"          json:"author"`
+	Replies         []Message  `bson:"-"               json:"replies,omitempty"`
+	Score           float64    `bson:"score,omitempty" json:"score,omitempty"`
+	Highlights      []string   `bson:"-"               json:"highlights,omitempty"`
+	notified        []string   // users notified after insert, computed with mentions
+	ruleMentions    []string   // users or groups mentioned by rules of topic, not in text
 }
 
 // MessageCriteria are used to list messages
@@ -61,6 +75,10 @@ type MessageCriteria struct {
 	InReplyOfIDRoot   string
 	AllIDMessage      string // search in IDMessage OR InReplyOfID OR InReplyOfIDRoot
 	Text              string
+	Search            string // full-text search, using text index
+	SortBy            string // empty: by date of creation, "relevance": by score of Search
+	After             string // cursor, messages older than this position
+	Before            string // cursor, messages newer than this position
 	Topic             string
 	Label             string
 	NotLabel          string
@@ -68,6 +86,9 @@ type MessageCriteria struct {
 	Tag               string
 	NotTag            string
 	AndTag            string
+	Reaction          string
+	ExternalKey       string
+	Attributes        []AttributeFilter
 	Username          string
 	DateMinCreation   string
 	DateMaxCreation   string
@@ -129,6 +150,24 @@ func buildMessageCriteria(criteria *MessageCriteria) bson.M {
 		}
 		query = append(query, queryTexts)
 	}
+	if criteria.ExternalKey != "" {
+		query = append(query, bson.M{"externalKey": criteria.ExternalKey})
+	}
+	for _, filter := range criteria.Attributes {
+		query = append(query, buildAttributeCriteria(filter))
+	}
+	if criteria.Reaction != "" {
+		query = append(query, bson.M{"reactions.text": bson.M{"$in": strings.Split(criteria.Reaction, ",")}})
+	}
+	if criteria.Search != "" {
+		query = append(query, bson.M{"$text": bson.M{"$search": criteria.Search}})
+	}
+	if criteria.After != "" {
+		query = append(query, buildCursorCriteria(criteria.After, "$lt"))
+	}
+	if criteria.Before != "" {
+		query = append(query, buildCursorCriteria(criteria.Before, "$gt"))
+	}
 	if criteria.Topic != "" {
 		queryTopics := bson.M{}
 		queryTopics["$or"] = []bson.M{}
@@ -247,45 +286,157 @@ func (message *Message) FindByID(id string) error {
 	return err
 }
 
+// FindByExternalKey returns message with given external key on topic
+func (message *Message) FindByExternalKey(topic, externalKey string) error {
+	return Store().clMessages.Find(bson.M{"topics": topic, "externalKey": externalKey}).One(&message)
+}
+
+// DuplicateExternalKeyError is returned on insert of a message
+// with an external key already used on topic
+type DuplicateExternalKeyError struct {
+	Topic       string
+	ExternalKey string
+	Message     Message // existing message
+}
+
+func (e *DuplicateExternalKeyError) Error() string {
+	return fmt.Sprintf("External key %s is already used on topic %s by message %s", e.ExternalKey, e.Topic, e.Message.ID)
+}
+
+// duplicateExternalKey returns a DuplicateExternalKeyError if err is a duplicate
+// external key on insert of message, inserted meanwhile by a concurrent insert.
+// err is returned otherwise
+func (message *Message) duplicateExternalKey(err error) error {
+	if !mgo.IsDup(err) || message.ExternalKey == "" {
+		return err
+	}
+	for _, topic := range message.Topics {
+		var existing = Message{}
+		if existing.FindByExternalKey(topic, message.ExternalKey) == nil && existing.ID != message.ID {
+			return &DuplicateExternalKeyError{Topic: topic, ExternalKey: message.ExternalKey, Message: existing}
+		}
+	}
+	return err
+}
+
+// checkExternalKey returns a DuplicateExternalKeyError if external key
+// of message is already used on topic
+func (message *Message) checkExternalKey(topic string) error {
+	if message.ExternalKey == "" {
+		return nil
+	}
+	var existing = Message{}
+	err := existing.FindByExternalKey(topic, message.ExternalKey)
+	if err == nil {
+		return &DuplicateExternalKeyError{Topic: topic, ExternalKey: message.ExternalKey, Message: existing}
+	} else if err != mgo.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// MessagesCursor contains cursors to get previous (Before) and next (After)
+// pages of a list of messages
+type MessagesCursor struct {
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// buildCursorCriteria returns criteria on position (dateCreation, _id)
+// of cursor, op is $lt for older messages, $gt for newer
+func buildCursorCriteria(cursor, op string) bson.M {
+	date, id, err := utils.DecodeCursor(cursor)
+	if err != nil {
+		log.Errorf("Error while decoding cursor %s", err)
+		// no message matches an invalid cursor
+		return bson.M{"_id": bson.M{"$exists": false}}
+	}
+	return bson.M{"$or": []bson.M{
+		bson.M{"dateCreation": bson.M{op: date}},
+		bson.M{"dateCreation": date, "_id": bson.M{op: id}},
+	}}
+}
+
 // ListMessages list messages with given criteria
 func ListMessages(criteria *MessageCriteria) ([]Message, error) {
+	messages, _, err := ListMessagesPage(criteria)
+	return messages, err
+}
+
+// ListMessagesPage list messages with given criteria, and returns cursors
+// to previous and next pages. Skip is ignored if criteria contains a cursor
+func ListMessagesPage(criteria *MessageCriteria) ([]Message, MessagesCursor, error) {
 	var messages []Message
+	var cursor MessagesCursor
+
+	var err error
+	if IsSortByAttribute(criteria.SortBy) {
+		messages, err = listMessagesSortedByAttribute(criteria)
+	} else {
+		query := Store().clMessages.Find(buildMessageCriteria(criteria))
+		if criteria.SortBy == SortByRelevance {
+			query = query.Select(bson.M{"score": bson.M{"$meta": "textScore"}}).
+				Sort("$textScore:score", "-dateCreation")
+		} else if criteria.Before != "" {
+			// nearest newer messages first, order is reversed below
+			query = query.Sort("dateCreation", "_id")
+		} else {
+			query = query.Sort("-dateCreation", "-_id")
+		}
+
+		if criteria.After == "" && criteria.Before == "" {
+			query = query.Skip(criteria.Skip)
+		}
 
-	err := Store().clMessages.Find(buildMessageCriteria(criteria)).
-		Sort("-dateCreation").
-		Skip(criteria.Skip).
-		Limit(criteria.Limit).
-		All(&messages)
+		err = query.
+			Limit(criteria.Limit).
+			All(&messages)
+	}
 
 	if err != nil {
 		log.Errorf("Error while Find All Messages %s", err)
 	}
 
+	if criteria.Before != "" && criteria.SortBy == "" {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+
 	if len(messages) == 0 {
-		return messages, nil
+		return messages, cursor, nil
 	}
 
-	if criteria.TreeView == "onetree" || criteria.TreeView == "fulltree" {
-		messages, err = initTree(messages, criteria)
-		if err != nil {
-			log.Errorf("Error while Find All Messages (getTree) %s", err)
+	// cursors are positions in messages sorted by date of creation
+	if criteria.SortBy == "" {
+		first, last := messages[0], messages[len(messages)-1]
+		cursor.Before = utils.EncodeCursor(first.DateCreation, first.ID)
+		// a page before a cursor always has older messages
+		if criteria.Before != "" || (criteria.Limit > 0 && len(messages) == criteria.Limit) {
+			cursor.After = utils.EncodeCursor(last.DateCreation, last.ID)
 		}
 	}
-	if criteria.TreeView == "onetree" {
-		messages, err = oneTreeMessages(messages, 1, criteria)
-	} else if criteria.TreeView == "fulltree" {
-		messages, err = fullTreeMessages(messages, 1, criteria)
+
+	if criteria.Search != "" {
+		terms := utils.SearchTerms(criteria.Search)
+		for i := range messages {
+			messages[i].Highlights = utils.Highlight(messages[i].Text, terms)
+		}
 	}
-	if err != nil {
-		return messages, err
+
+	if criteria.TreeView == "onetree" || criteria.TreeView == "fulltree" {
+		messages, err = loadThreads(messages, criteria)
+		if err != nil {
+			return messages, cursor, err
+		}
 	}
 
 	if criteria.TreeView == "onetree" &&
 		(criteria.LimitMinNbReplies != "" || criteria.LimitMaxNbReplies != "") {
-		return filterNbReplies(messages, criteria)
+		messages, err = filterNbReplies(messages, criteria)
 	}
 
-	return messages, err
+	return messages, cursor, err
 }
 
 func filterNbReplies(messages []Message, criteria *MessageCriteria) ([]Message, error) {
@@ -322,165 +473,162 @@ func filterNbReplies(messages []Message, criteria *MessageCriteria) ([]Message,
 	return messagesFiltered, nil
 }
 
-func initTree(messages []Message, criteria *MessageCriteria) ([]Message, error) {
-	var ids []string
-	idMessages := ""
-	for i := 0; i <= len(messages)-1; i++ {
-		if utils.ArrayContains(ids, messages[i].ID) {
-			continue
+// thread is a root message with its replies, as returned by loadThreads aggregation
+type thread struct {
+	Message `bson:",inline"`
+	Replies []Message `bson:"replies"`
+}
+
+// loadThreads returns threads of messages with one aggregation: root messages
+// with all their replies, flat under root with treeView onetree, under their
+// parent with fulltree. Threads are in order of their first message in messages.
+// NotLabel and NotTag of criteria exclude a thread if its root is excluded,
+// a reply only otherwise
+func loadThreads(messages []Message, criteria *MessageCriteria) ([]Message, error) {
+	var rootIDs []string
+	for _, m := range messages {
+		id := m.ID
+		if m.InReplyOfIDRoot != "" {
+			id = m.InReplyOfIDRoot
+		}
+		if !utils.ArrayContains(rootIDs, id) {
+			rootIDs = append(rootIDs, id)
 		}
-		ids = append(ids, messages[i].ID)
-		idMessages += messages[i].ID + ","
 	}
-
-	if idMessages == "" {
+	if len(rootIDs) == 0 {
 		return messages, nil
 	}
 
-	c := &MessageCriteria{
-		AllIDMessage: idMessages[:len(idMessages)-1],
-		NotLabel:     criteria.NotLabel,
-		NotTag:       criteria.NotTag,
-	}
-	var msgs []Message
-	err := Store().clMessages.Find(buildMessageCriteria(c)).Sort("-dateCreation").All(&msgs)
-	if err != nil {
-		log.Errorf("Error while Find Messages in getTree %s", err)
+	c := &MessageCriteria{NotLabel: criteria.NotLabel, NotTag: criteria.NotTag}
+	pipeline := []bson.M{
+		bson.M{"$match": bson.M{"$and": []bson.M{
+			bson.M{"_id": bson.M{"$in": rootIDs}, "inReplyOfIDRoot": ""},
+			buildMessageCriteria(c),
+		}}},
+		bson.M{"$lookup": bson.M{
+			"from":         Store().clMessages.Name,
+			"localField":   "_id",
+			"foreignField": "inReplyOfIDRoot",
+			"as":           "replies",
+		}},
+	}
+	var threads []thread
+	if err := Store().clMessages.Pipe(pipeline).All(&threads); err != nil {
+		log.Errorf("Error while loading threads %s", err)
 		return messages, err
 	}
-	return msgs, nil
-}
 
-func oneTreeMessages(messages []Message, nloop int, criteria *MessageCriteria) ([]Message, error) {
-	var tree []Message
-	if nloop > 25 {
-		e := "Infinite loop detected in oneTreeMessages"
-		for i := 0; i <= len(messages)-1; i++ {
-			e += " id:" + messages[i].ID
-		}
-		log.Errorf(e)
-		return tree, errors.New(e)
+	byID := make(map[string]thread, len(threads))
+	for _, t := range threads {
+		byID[t.ID] = t
 	}
 
-	replies := make(map[string][]Message)
-	for i := 0; i <= len(messages)-1; i++ {
-		if messages[i].InReplyOfIDRoot == "" {
-			var replyAdded = false
-			for _, msgReply := range replies[messages[i].ID] {
-				messages[i].Replies = append(messages[i].Replies, msgReply)
-				replyAdded = true
-			}
-			if replyAdded || nloop > 1 {
-				tree = append(tree, messages[i])
-				delete(replies, messages[i].ID)
-			} else if nloop == 1 && !replyAdded {
-				replies[messages[i].ID] = append(replies[messages[i].ID], messages[i])
-			}
+	notLabels, notTags := splitCriteria(criteria.NotLabel), splitCriteria(criteria.NotTag)
+	tree := make([]Message, 0, len(threads))
+	for _, id := range rootIDs {
+		t, ok := byID[id]
+		if !ok {
 			continue
 		}
-		replies[messages[i].InReplyOfIDRoot] = append(replies[messages[i].InReplyOfIDRoot], messages[i])
-	}
+		var replies []Message
+		for _, r := range t.Replies {
+			if !r.hasOneOf(notLabels, notTags) {
+				replies = append(replies, r)
+			}
+		}
+		sort.Sort(messagesByDateDesc(replies))
 
-	if len(replies) == 0 {
-		return tree, nil
-	}
-	t, err := getTree(replies, criteria)
-	if err != nil {
-		return tree, err
+		root := t.Message
+		if criteria.TreeView == "fulltree" {
+			root.Replies = fullTreeReplies(root.ID, replies)
+		} else {
+			root.Replies = replies
+		}
+		tree = append(tree, root)
 	}
-
-	ft, err := oneTreeMessages(t, nloop+1, criteria)
-	return append(ft, tree...), err
+	return tree, nil
 }
 
-func fullTreeMessages(messages []Message, nloop int, criteria *MessageCriteria) ([]Message, error) {
-	var tree []Message
-	if nloop > 10 {
-		e := "Infinite loop detected in fullTreeMessages"
-		for i := 0; i <= len(messages)-1; i++ {
-			e += " id:" + messages[i].ID
+// fullTreeReplies returns replies of root, each reply under its parent.
+// A reply to a message excluded from replies is under root
+func fullTreeReplies(rootID string, replies []Message) []Message {
+	ids := make(map[string]bool, len(replies))
+	for _, r := range replies {
+		ids[r.ID] = true
+	}
+	children := make(map[string][]Message)
+	for _, r := range replies {
+		parent := r.InReplyOfID
+		if !ids[parent] || parent == r.ID {
+			parent = rootID
 		}
-		log.Errorf(e)
-		return tree, errors.New(e)
+		children[parent] = append(children[parent], r)
 	}
+	return attachReplies(rootID, children)
+}
 
-	replies := make(map[string][]Message)
-	var alreadyDone []string
-
-	for i := 0; i <= len(messages)-1; i++ {
-		if utils.ArrayContains(alreadyDone, messages[i].ID) {
-			continue
-		}
+func attachReplies(id string, children map[string][]Message) []Message {
+	replies := children[id]
+	// each reply is attached once, even with a loop between replies
+	delete(children, id)
+	for i := range replies {
+		replies[i].Replies = attachReplies(replies[i].ID, children)
+	}
+	return replies
+}
 
-		var replyAdded = false
-		for _, msgReply := range replies[messages[i].ID] {
-			messages[i].Replies = append(messages[i].Replies, msgReply)
-			delete(replies, messages[i].ID)
-			replyAdded = true
+// hasOneOf returns true if message has one of labels or one of tags
+func (message *Message) hasOneOf(labels, tags []string) bool {
+	for _, l := range message.Labels {
+		if utils.ArrayContains(labels, l.Text) {
+			return true
 		}
-		if messages[i].InReplyOfIDRoot == "" {
-			if replyAdded || nloop > 1 {
-				tree = append(tree, messages[i])
-			} else if nloop == 1 && !replyAdded {
-				replies[messages[i].ID] = append(replies[messages[i].ID], messages[i])
-			}
-			continue
-		}
-		replies[messages[i].InReplyOfID] = append(replies[messages[i].InReplyOfID], messages[i])
-		alreadyDone = append(alreadyDone, messages[i].ID)
 	}
+	return utils.ItemInBothArrays(message.Tags, tags)
+}
 
-	if len(replies) == 0 {
-		return tree, nil
+func splitCriteria(value string) []string {
+	if value == "" {
+		return []string{}
 	}
-	t, err := getTree(replies, criteria)
-	if err != nil {
-		return tree, err
-	}
-	ft, err := fullTreeMessages(t, nloop+1, criteria)
-	return append(ft, tree...), err
+	return strings.Split(value, ",")
 }
 
-func getTree(messagesIn map[string][]Message, criteria *MessageCriteria) ([]Message, error) {
-	var messages []Message
+// messagesByDateDesc sorts messages, newest first
+type messagesByDateDesc []Message
 
-	toDelete := false
-	for idMessage := range messagesIn {
-		toDelete = false
-		c := &MessageCriteria{
-			AllIDMessage: idMessage,
-			NotLabel:     criteria.NotLabel,
-			NotTag:       criteria.NotTag,
-		}
-		var msgs []Message
-		err := Store().clMessages.Find(buildMessageCriteria(c)).Sort("-dateCreation").All(&msgs)
-		if err != nil {
-			log.Errorf("Error while Find Messages in getTree %s", err)
-			return messages, err
-		}
+func (m messagesByDateDesc) Len() int      { return len(m) }
+func (m messagesByDateDesc) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+func (m messagesByDateDesc) Less(i, j int) bool {
+	if m[i].DateCreation != m[j].DateCreation {
+		return m[i].DateCreation > m[j].DateCreation
+	}
+	return m[i].ID > m[j].ID
+}
 
-		if criteria.NotLabel != "" || criteria.NotTag != "" {
-			toDelete = true
-		}
-		for _, m := range msgs {
-			if m.ID == idMessage && m.InReplyOfIDRoot == "" && toDelete {
-				toDelete = false
-				break
-			}
-		}
+// Insert a new message on one topic
+func (message *Message) Insert(user User, topic Topic, text, inReplyOfID string, dateCreation int64, labels []Label, isNotificationFromMention bool) error {
+	err := message.prepare(user, topic, text, inReplyOfID, dateCreation, labels, isNotificationFromMention, nil)
+	if err != nil {
+		return err
+	}
 
-		if toDelete {
-			delete(messagesIn, idMessage)
-		} else {
-			messages = append(messages, msgs...)
+	err = Store().clMessages.Insert(message)
+	if err != nil {
+		if errDup := message.duplicateExternalKey(err); errDup != err {
+			return errDup
 		}
+		log.Errorf("Error while inserting new message %s", err)
+		return err
 	}
 
-	return messages, nil
+	message.afterInsert(user, topic)
+	return nil
 }
 
-// Insert a new message on one topic
-func (message *Message) Insert(user User, topic Topic, text, inReplyOfID string, dateCreation int64, labels []Label, isNotificationFromMention bool) error {
+// prepare checks and fills a new message before its insert.
+// messageReference is message replied, loaded from inReplyOfID if nil
+func (message *Message) prepare(user User, topic Topic, text, inReplyOfID string, dateCreation int64, labels []Label, isNotificationFromMention bool, messageReference *Message) error {
 
 	if !isNotificationFromMention {
 		notificationsTopic := fmt.Sprintf("/Private/%s/Notifications", user.Username)
@@ -498,7 +646,18 @@ func (message *Message) Insert(user User, topic Topic, text, inReplyOfID string,
 	if err != nil {
 		return err
 	}
-	message.ID = bson.NewObjectId().Hex()
+
+	if err := checkAttributes(message.Attributes, false); err != nil {
+		return err
+	}
+
+	if err := message.checkExternalKey(topic.Topic); err != nil {
+		return err
+	}
+
+	if message.ID == "" {
+		message.ID = bson.NewObjectId().Hex()
+	}
 	message.InReplyOfID = inReplyOfID
 	dateToStore := time.Now().Unix()
 
@@ -518,10 +677,12 @@ func (message *Message) Insert(user User, topic Topic, text, inReplyOfID string,
 	}
 
 	if inReplyOfID != "" {
-		var messageReference = &Message{}
-		err = messageReference.FindByID(inReplyOfID)
-		if err != nil {
-			return err
+		if messageReference == nil {
+			messageReference = &Message{}
+			err = messageReference.FindByID(inReplyOfID)
+			if err != nil {
+				return err
+			}
 		}
 		if messageReference.InReplyOfID != "" {
 			message.InReplyOfIDRoot = messageReference.InReplyOfIDRoot
@@ -560,54 +721,57 @@ func (message *Message) Insert(user User, topic Topic, text, inReplyOfID string,
 	message.Tags = hashtag.ExtractHashtags(message.Text)
 	message.Urls = xurls.Strict.FindAllString(message.Text, -1)
 
-	topicPrivate := "/Private/"
-	if !strings.HasPrefix(topic.Topic, topicPrivate) {
-		usernamesMentions := extractUsersMentions(message.Text)
-		message.UserMentions = usernamesMentions
+	if labels != nil {
+		message.Labels, err = topic.CheckCatalogLabels(checkLabels(labels))
+		if err != nil {
+			return err
+		}
 	}
 
-	if labels != nil {
-		message.Labels = checkLabels(labels)
+	// validation checks tags and text of author, before rules
+	if !isNotificationFromMention {
+		if err := message.checkValidation(topic, message.Tags); err != nil {
+			return err
+		}
 	}
 
-	err = Store().clMessages.Insert(message)
-	if err != nil {
-		log.Errorf("Error while inserting new message %s", err)
-		return err
+	// rules of topic are applied on new threads, before mentions
+	if !isNotificationFromMention && inReplyOfID == "" && len(topic.Rules) > 0 {
+		authorTags := append([]string(nil), message.Tags...)
+		message.applyRules(topic, topic.Rules)
+		if message.Topics[0] != topic.Topic {
+			if topic, err = message.moveToRuleTopic(user, authorTags); err != nil {
+				return err
+			}
+		}
 	}
 
+	topicPrivate := "/Private/"
 	if !strings.HasPrefix(topic.Topic, topicPrivate) {
-		message.insertNotifications(user)
+		if err := message.extractMentions(user, topic); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func extractUsersMentions(text string) []string {
-	usernames := hashtag.ExtractMentions(text)
-	var usernamesChecked []string
-
-	for _, username := range usernames {
-		var user = User{}
-		if err := user.FindByUsername(username); err == nil {
-			usernamesChecked = append(usernamesChecked, user.Username)
-		}
+// afterInsert sends notifications of a new message
+func (message *Message) afterInsert(user User, topic Topic) {
+	if !strings.HasPrefix(topic.Topic, "/Private/") {
+		message.insertNotifications(user)
 	}
-	return usernamesChecked
 }
 
 func (message *Message) insertNotifications(author User) {
-	if len(message.UserMentions) == 0 {
-		return
-	}
-	for _, userMention := range message.UserMentions {
-		message.insertNotification(author, userMention)
+	for _, username := range message.notified {
+		message.insertNotification(author, username)
 	}
 }
 
 func (message *Message) insertNotification(author User, usernameMention string) {
 	notif := Message{}
 	text := fmt.Sprintf("#mention #idMessage:%s #topic:%s %s", message.ID, message.Topics[0], message.Text)
-	topicname := fmt.Sprintf("/Private/%s/Notifications", usernameMention)
+	topicname := GetPrivateTopicNotificationsName(usernameMention)
 	labels := []Label{Label{Text: "unread", Color: "d04437"}}
 	var topic = Topic{}
 	if err := topic.FindByTopic(topicname, false); err != nil {
@@ -617,7 +781,9 @@ func (message *Message) insertNotification(author User, usernameMention string)
 	if err := notif.Insert(author, topic, text, "", -1, labels, true); err != nil {
 		// not throw err here, just log
 		log.Errorf("Error while inserting notification message for %s, error: %s", usernameMention, err.Error())
+		return
 	}
+	go wsNotificationsCount(usernameMention)
 }
 
 func checkLabels(labels []Label) []Label {
@@ -631,12 +797,13 @@ func checkLabels(labels []Label) []Label {
 	return labelsChecked
 }
 
-// CheckAndFixText truncates to maxLength (parameter on topic) characters
+// CheckAndFixText truncates to maxLength (parameter on topic) characters,
+// or returns a ValidationError if validation of topic rejects too long texts
 // if len < 1, return error
 func (message *Message) CheckAndFixText(topic Topic) error {
 	text := strings.TrimSpace(message.Text)
 	if len(text) < 1 {
-		return fmt.Errorf("Invalid Text:%s", message.Text)
+		return &ValidationError{Topic: topic.Topic, Rule: ValidationRuleText, Reason: "text is empty"}
 	}
 
 	maxLength := DefaultMessageMaxSize
@@ -644,80 +811,183 @@ func (message *Message) CheckAndFixText(topic Topic) error {
 		maxLength = topic.MaxLength
 	}
 
-	if len(text) > maxLength {
-		text = text[0:maxLength]
+	if length := utf8.RuneCountInString(text); length > maxLength {
+		if topic.Validation != nil && topic.Validation.RejectTooLong {
+			return &ValidationError{Topic: topic.Topic, Rule: ValidationRuleMaxLength,
+				Reason: fmt.Sprintf("text has %d characters, %d max", length, maxLength)}
+		}
+		text = utils.TruncateRunes(text, maxLength)
 	}
 	message.Text = text
 	return nil
 }
 
-// Update updates a message from database
-func (message *Message) Update(user User, topic Topic) error {
-
+// Update updates text of a message, previous text is kept as a revision.
+// Revision is inserted before update of text, which is done only if message
+// was not updated meanwhile
+func (message *Message) Update(user User, topic Topic, newText string) error {
+	previousText := message.Text
+	message.Text = newText
 	err := message.CheckAndFixText(topic)
 	if err != nil {
 		return err
 	}
+	tags := hashtag.ExtractHashtags(message.Text)
+	if err := message.checkValidation(topic, tags); err != nil {
+		return err
+	}
+	// tags not from text, added by rules, by tag action or by a webhook, are kept
+	previousTags := hashtag.ExtractHashtags(previousText)
+	for _, tag := range message.Tags {
+		if !utils.ArrayContains(previousTags, tag) && !utils.ArrayContains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
 
+	now := time.Now().Unix()
+	revision := &Revision{
+		IDMessage:    message.ID,
+		Revision:     message.NbRevisions,
+		Text:         previousText,
+		Author:       Author{Username: user.Username, Fullname: user.Fullname},
+		DateRevision: now,
+	}
+	if err := revision.insert(); err != nil {
+		if mgo.IsDup(err) {
+			return fmt.Errorf("Message %s was updated meanwhile, please retry", message.ID)
+		}
+		return err
+	}
+
+	// messages without revision have no nbRevisions before revisions
+	var nbRevisions interface{} = message.NbRevisions
+	if message.NbRevisions == 0 {
+		nbRevisions = bson.M{"$in": []interface{}{0, nil}}
+	}
 	err = Store().clMessages.Update(
-		bson.M{"_id": message.ID},
-		bson.M{"$set": bson.M{
-			"text":         message.Text,
-			"dateUpdate":   time.Now().Unix(),
-			"tags":         hashtag.ExtractHashtags(message.Text),
-			"userMentions": hashtag.ExtractMentions(message.Text),
-			"urls":         xurls.Strict.FindAllString(message.Text, -1),
-		}})
+		bson.M{"_id": message.ID, "nbRevisions": nbRevisions},
+		bson.M{
+			"$set": bson.M{
+				"text":         message.Text,
+				"dateUpdate":   now,
+				"tags":         tags,
+				"userMentions": hashtag.ExtractMentions(message.Text),
+				"urls":         xurls.Strict.FindAllString(message.Text, -1),
+			},
+			"$inc": bson.M{"nbRevisions": 1},
+		})
 	if err != nil {
+		Store().clRevisions.RemoveId(revision.ID)
+		if err == mgo.ErrNotFound {
+			return fmt.Errorf("Message %s was updated meanwhile, please retry", message.ID)
+		}
 		log.Errorf("Error while update a message %s", err)
+		return err
 	}
 
+	message.NbRevisions++
+	message.DateUpdate = now
+	message.Tags = tags
 	return nil
 }
 
-// Move moves a message to another topic
+// Move moves a thread to another topic: original topic of each message of thread,
+// its first topic, is replaced by newTopic. Topics where thread is shared, and Tasks
+// topics, are kept
 func (message *Message) Move(user User, newTopic Topic) error {
 
 	// check Delete and RW are done in controller
-	c := &MessageCriteria{
-		IDMessage: message.ID,
-		TreeView:  "onetree",
-	}
-
-	msgs, err := ListMessages(c)
+	replies, err := message.AllReplies()
 	if err != nil {
-		return fmt.Errorf("Error while list Messages in Delete %s", err)
-	}
-	if len(msgs) != 1 {
-		return fmt.Errorf("Error while list Messages in Delete (%s not unique!)", message.ID)
+		return fmt.Errorf("Error while list replies in Move %s", err)
 	}
 
-	for _, e := range msgs[0].Replies {
-		if len(e.Topics) != 1 {
-			return fmt.Errorf("A reply belongs more than one topic, you can't move this thread.")
+	// here, ok, we can move
+	// messages of thread are unpinned from their old topic
+	oldTopic := message.Topics[0]
+	if err := unpinMessages(message.threadSelector(), oldTopic); err != nil {
+		log.Errorf("Error while unpinning messages of thread %s: %s", message.ID, err)
+	}
+
+	bulk := Store().clMessages.Bulk()
+	for _, m := range append([]Message{*message}, replies...) {
+		topics := []string{newTopic.Topic}
+		for _, t := range m.Topics {
+			if t != oldTopic && t != newTopic.Topic {
+				topics = append(topics, t)
+			}
+		}
+		bulk.Update(bson.M{"_id": m.ID}, bson.M{"$set": bson.M{"topics": topics}})
+		if m.ID == message.ID {
+			message.Topics = topics
 		}
 	}
-
-	// here, ok, we can move
-	topicUpdate := []string{newTopic.Topic}
-	_, err = Store().clMessages.UpdateAll(
-		bson.M{"$or": []bson.M{bson.M{"_id": message.ID}, bson.M{"inReplyOfIDRoot": message.ID}}},
-		bson.M{"$set": bson.M{"topics": topicUpdate}})
-
-	if err != nil {
+	if _, err := bulk.Run(); err != nil {
 		log.Errorf("Error while update messages (move topic to %s) idMsgRoot:%s err:%s", newTopic.Topic, message.ID, err)
+		return err
 	}
 
 	return nil
 }
 
-// Delete deletes a message from database
+// AllReplies returns replies of message, direct or not, loaded with its thread
+func (message *Message) AllReplies() ([]Message, error) {
+	rootID := message.InReplyOfIDRoot
+	if rootID == "" {
+		rootID = message.ID
+	}
+	var thread []Message
+	if err := Store().clMessages.Find(bson.M{"inReplyOfIDRoot": rootID}).All(&thread); err != nil {
+		return nil, err
+	}
+	if message.InReplyOfIDRoot == "" {
+		return thread, nil
+	}
+
+	children := make(map[string][]Message)
+	for _, m := range thread {
+		children[m.InReplyOfID] = append(children[m.InReplyOfID], m)
+	}
+	var replies []Message
+	for parents := []string{message.ID}; len(parents) > 0; parents = parents[1:] {
+		for _, m := range children[parents[0]] {
+			replies = append(replies, m)
+			parents = append(parents, m.ID)
+		}
+		// each reply is added once, even with a loop between replies
+		delete(children, parents[0])
+	}
+	return replies, nil
+}
+
+// Delete deletes a message from database, with its revisions.
+// With cascade, all replies of message are deleted too
 func (message *Message) Delete(cascade bool) error {
+	selector := bson.M{"_id": message.ID}
 	if cascade {
-		_, err := Store().clMessages.RemoveAll(bson.M{"$or": []bson.M{bson.M{"_id": message.ID}, bson.M{"inReplyOfIDRoot": message.ID}}})
+		replies, err := message.AllReplies()
+		if err != nil {
+			return err
+		}
+		ids := []string{message.ID}
+		for _, r := range replies {
+			ids = append(ids, r.ID)
+		}
+		selector = bson.M{"_id": bson.M{"$in": ids}}
+	}
+
+	if err := removeRevisions(selector); err != nil {
+		log.Errorf("Error while removing revisions of message %s: %s", message.ID, err)
+	}
+	if err := unpinMessages(selector, ""); err != nil {
+		log.Errorf("Error while unpinning message %s: %s", message.ID, err)
+	}
+
+	if cascade {
+		_, err := Store().clMessages.RemoveAll(selector)
 		return err
 	}
-	return Store().clMessages.Remove(bson.M{"_id": message.ID})
+	return Store().clMessages.Remove(selector)
 }
 
 func (message *Message) getLabel(label string) (int, Label, error) {
@@ -828,38 +1098,20 @@ func (message *Message) RemoveTag(tag string) error {
 	return nil
 }
 
-// Like add a like to a message
+// Like add a like to a message, as a "like" reaction
 func (message *Message) Like(user User) error {
 	if utils.ArrayContains(message.Likers, user.Username) {
 		return fmt.Errorf("Like not possible, %s is already a liker of this message", user.Username)
 	}
-	err := Store().clMessages.Update(
-		bson.M{"_id": message.ID},
-		bson.M{"$set": bson.M{"dateUpdate": time.Now().Unix()},
-			"$inc":  bson.M{"nbLikes": 1},
-			"$push": bson.M{"likers": user.Username}})
-
-	if err != nil {
-		return err
-	}
-	return nil
+	return message.React(user, ReactionLike)
 }
 
-// Unlike removes a like from one message
+// Unlike removes a like from one message, and its "like" reaction
 func (message *Message) Unlike(user User) error {
 	if !utils.ArrayContains(message.Likers, user.Username) {
 		return fmt.Errorf("Unlike not possible, %s is not a liker of this message", user.Username)
 	}
-	err := Store().clMessages.Update(
-		bson.M{"_id": message.ID},
-		bson.M{"$set": bson.M{"dateUpdate": time.Now().Unix()},
-			"$inc":  bson.M{"nbLikes": -1},
-			"$pull": bson.M{"likers": user.Username}})
-
-	if err != nil {
-		return err
-	}
-	return nil
+	return message.Unreact(user, ReactionLike)
 }
 
 // GetPrivateTopicTaskName return Tasks Topic name of user
@@ -878,7 +1130,7 @@ func (message *Message) addOrRemoveFromTasks(action string, user User, topic Top
 	}
 
 	_, err := Store().clMessages.UpdateAll(
-		bson.M{"$or": []bson.M{bson.M{"_id": idRoot}, bson.M{"inReplyOfIDRoot": idRoot}}},
+		message.threadSelector(),
 		bson.M{"$" + action: bson.M{"topics": topicTasksName}})
 
 	if err != nil {
@@ -915,6 +1167,8 @@ func CountMsgSinceDate(topic string, date int64) (int, error) {
 func changeUsernameOnMessages(oldUsername, newUsername string) {
 	changeAuthorUsernameOnMessages(oldUsername, newUsername)
 	changeUsernameOnMessagesTopics(oldUsername, newUsername)
+	changeAuthorUsernameOnRevisions(oldUsername, newUsername)
+	changeAuthorUsernameOnScheduledMessages(oldUsername, newUsername)
 }
 
 func changeAuthorUsernameOnMessages(oldUsername, newUsername string) error {
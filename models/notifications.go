@@ -0,0 +1,233 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ovh/tat/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// NotificationsRead struct, read state of notifications of a user: notifications
+// created before DateRead are read, and notifications with an id in IDs.
+// IDs contains only notifications created after DateRead
+type NotificationsRead struct {
+	DateRead int64    `bson:"dateRead" json:"dateRead"`
+	IDs      []string `bson:"ids"      json:"ids,omitempty"`
+}
+
+// NotificationsCount struct, number of unread notifications of a user,
+// and by topic of message at origin of notification
+type NotificationsCount struct {
+	Count   int            `json:"count"`
+	ByTopic map[string]int `json:"byTopic"`
+}
+
+// GetPrivateTopicNotificationsName returns Notifications topic name of user
+func GetPrivateTopicNotificationsName(username string) string {
+	return "/Private/" + username + "/Notifications"
+}
+
+// notificationsDateRead returns date before which all notifications of user are read
+func (user *User) notificationsDateRead() int64 {
+	if user.NotificationsRead == nil {
+		return 0
+	}
+	return user.NotificationsRead.DateRead
+}
+
+// unreadNotificationsSelector returns selector of unread notifications of user:
+// with label unread, created after DateRead and not read one by one
+func (user *User) unreadNotificationsSelector() bson.M {
+	selector := bson.M{
+		"topics":       GetPrivateTopicNotificationsName(user.Username),
+		"labels.text":  "unread",
+		"dateCreation": bson.M{"$gt": user.notificationsDateRead()},
+	}
+	if user.NotificationsRead != nil && len(user.NotificationsRead.IDs) > 0 {
+		selector["_id"] = bson.M{"$nin": user.NotificationsRead.IDs}
+	}
+	return selector
+}
+
+// ListUnreadNotifications returns unread notifications of user, newest first
+func (user *User) ListUnreadNotifications(skip, limit int) ([]Message, error) {
+	var notifications []Message
+	err := Store().clMessages.Find(user.unreadNotificationsSelector()).
+		Sort("-dateCreation", "-_id").
+		Skip(skip).
+		Limit(limit).
+		All(&notifications)
+	if err != nil {
+		log.Errorf("Error while listing notifications of %s: %s", user.Username, err)
+	}
+	return notifications, err
+}
+
+// CountUnreadNotifications returns number of unread notifications of user, and by
+// topic of message at origin of notification, from its tag topic:
+func (user *User) CountUnreadNotifications() (NotificationsCount, error) {
+	count := NotificationsCount{ByTopic: map[string]int{}}
+	selector := user.unreadNotificationsSelector()
+
+	var err error
+	if count.Count, err = Store().clMessages.Find(selector).Count(); err != nil || count.Count == 0 {
+		return count, err
+	}
+
+	var byTopic []struct {
+		Tag   string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	err = Store().clMessages.Pipe([]bson.M{
+		bson.M{"$match": selector},
+		bson.M{"$unwind": "$tags"},
+		bson.M{"$match": bson.M{"tags": bson.RegEx{Pattern: "^topic:"}}},
+		bson.M{"$group": bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}},
+	}).All(&byTopic)
+	if err != nil {
+		log.Errorf("Error while counting notifications of %s by topic: %s", user.Username, err)
+		return count, err
+	}
+	for _, t := range byTopic {
+		count.ByTopic[strings.TrimPrefix(t.Tag, "topic:")] = t.Count
+	}
+	return count, nil
+}
+
+// MarkNotificationsRead marks notifications with ids as read
+func (user *User) MarkNotificationsRead(ids []string) error {
+	if len(ids) == 0 {
+		return fmt.Errorf("Invalid ids, at least one id of notification is expected")
+	}
+	err := Store().clUsers.Update(
+		bson.M{"_id": user.ID},
+		bson.M{"$addToSet": bson.M{"notificationsRead.ids": bson.M{"$each": ids}}})
+	if err != nil {
+		log.Errorf("Error while marking notifications of %s as read: %s", user.Username, err)
+		return err
+	}
+	if user.NotificationsRead == nil {
+		user.NotificationsRead = &NotificationsRead{}
+	}
+	user.NotificationsRead.IDs = append(user.NotificationsRead.IDs, ids...)
+	return user.compactNotificationsRead()
+}
+
+// MarkAllNotificationsRead marks notifications created before dateMax as read,
+// all notifications if dateMax is 0 or in the future
+func (user *User) MarkAllNotificationsRead(dateMax int64) error {
+	if dateMax < 0 {
+		return fmt.Errorf("Invalid dateMax %d", dateMax)
+	}
+	// a date in the future would mark as read notifications not yet received
+	if now := time.Now().Unix(); dateMax == 0 || dateMax > now {
+		dateMax = now
+	}
+	if dateMax <= user.notificationsDateRead() {
+		return nil
+	}
+	err := Store().clUsers.Update(
+		bson.M{"_id": user.ID},
+		bson.M{"$max": bson.M{"notificationsRead.dateRead": dateMax}})
+	if err != nil {
+		log.Errorf("Error while marking notifications of %s as read: %s", user.Username, err)
+		return err
+	}
+	if user.NotificationsRead == nil {
+		user.NotificationsRead = &NotificationsRead{}
+	}
+	user.NotificationsRead.DateRead = dateMax
+	return user.compactNotificationsRead()
+}
+
+// compactNotificationsRead moves DateRead just before oldest unread notification,
+// and removes from IDs notifications created before DateRead, or deleted
+func (user *User) compactNotificationsRead() error {
+	read := user.NotificationsRead
+	dateRead := read.DateRead
+
+	var oldest Message
+	err := Store().clMessages.Find(user.unreadNotificationsSelector()).
+		Select(bson.M{"dateCreation": 1}).
+		Sort("dateCreation").
+		One(&oldest)
+	if err == nil {
+		dateRead = oldest.DateCreation - 1
+	} else if err == mgo.ErrNotFound && len(read.IDs) > 0 {
+		// all notifications are read, until newest one read
+		var newest Message
+		err = Store().clMessages.Find(bson.M{"_id": bson.M{"$in": read.IDs}}).
+			Select(bson.M{"dateCreation": 1}).
+			Sort("-dateCreation").
+			One(&newest)
+		if err == nil {
+			dateRead = newest.DateCreation
+		}
+	}
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	if dateRead < read.DateRead {
+		dateRead = read.DateRead
+	}
+
+	var ids []string
+	if len(read.IDs) > 0 {
+		err := Store().clMessages.Find(bson.M{
+			"_id":          bson.M{"$in": read.IDs},
+			"dateCreation": bson.M{"$gt": dateRead},
+		}).Distinct("_id", &ids)
+		if err != nil {
+			return err
+		}
+	}
+	var removed []string
+	for _, id := range read.IDs {
+		if !utils.ArrayContains(ids, id) {
+			removed = append(removed, id)
+		}
+	}
+
+	// ids marked as read meanwhile by another request are kept
+	update := bson.M{"$max": bson.M{"notificationsRead.dateRead": dateRead}}
+	if len(removed) > 0 {
+		update["$pullAll"] = bson.M{"notificationsRead.ids": removed}
+	}
+	var updated User
+	_, err = Store().clUsers.Find(bson.M{"_id": user.ID}).
+		Select(bson.M{"notificationsRead": 1}).
+		Apply(mgo.Change{Update: update, ReturnNew: true}, &updated)
+	if err != nil {
+		log.Errorf("Error while compacting read notifications of %s: %s", user.Username, err)
+		return err
+	}
+	user.NotificationsRead = updated.NotificationsRead
+	return nil
+}
+
+// wsNotificationsCount writes event notifications with number of unread
+// notifications to connected clients of user
+func wsNotificationsCount(username string) {
+	if !isActiveUser(username) {
+		return
+	}
+	var user = User{}
+	if err := user.FindByUsername(username); err != nil {
+		return
+	}
+	user.WSNotificationsCount()
+}
+
+// WSNotificationsCount writes event notifications with number of unread
+// notifications of user to its connected clients
+func (user *User) WSNotificationsCount() {
+	count, err := user.CountUnreadNotifications()
+	if err != nil {
+		return
+	}
+	WSNotifications(&WSNotificationsJSON{Username: user.Username, Count: count.Count, ByTopic: count.ByTopic})
+}
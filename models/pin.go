@@ -0,0 +1,115 @@
+package models
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ovh/tat/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MaxPinnedMessages is the max number of messages pinned on a topic
+const MaxPinnedMessages = 20
+
+// PinMessage pins a message of topic, at position in pinned messages,
+// at the end if position is negative
+func (topic *Topic) PinMessage(username string, message Message, position int) error {
+	if !utils.ArrayContains(message.Topics, topic.Topic) {
+		return fmt.Errorf("Message %s is not on topic %s", message.ID, topic.Topic)
+	}
+	if utils.ArrayContains(topic.Pinned, message.ID) {
+		return fmt.Errorf("Message %s is already pinned on topic %s", message.ID, topic.Topic)
+	}
+	if len(topic.Pinned) >= MaxPinnedMessages {
+		return fmt.Errorf("Pin not possible, %d messages max can be pinned on a topic", MaxPinnedMessages)
+	}
+
+	each := bson.M{"$each": []string{message.ID}}
+	if position >= 0 {
+		each["$position"] = position
+	}
+	var updated Topic
+	_, err := Store().clTopics.Find(bson.M{
+		"_id":    topic.ID,
+		"pinned": bson.M{"$ne": message.ID},
+		fmt.Sprintf("pinned.%d", MaxPinnedMessages-1): bson.M{"$exists": false},
+	}).Select(bson.M{"pinned": 1}).Apply(mgo.Change{
+		Update:    bson.M{"$push": bson.M{"pinned": each}},
+		ReturnNew: true,
+	}, &updated)
+
+	if err == mgo.ErrNotFound {
+		return fmt.Errorf("Pin not possible, pinned messages of topic %s are updated concurrently", topic.Topic)
+	} else if err != nil {
+		log.Errorf("Error while pinning message %s on topic %s: %s", message.ID, topic.Topic, err)
+		return err
+	}
+	topic.Pinned = updated.Pinned
+	return topic.addToHistory(bson.M{"_id": topic.ID}, username, "pin message "+message.ID)
+}
+
+// UnpinMessage removes a message from pinned messages of topic
+func (topic *Topic) UnpinMessage(username, idMessage string) error {
+	var updated Topic
+	_, err := Store().clTopics.Find(bson.M{
+		"_id":    topic.ID,
+		"pinned": idMessage,
+	}).Select(bson.M{"pinned": 1}).Apply(mgo.Change{
+		Update:    bson.M{"$pull": bson.M{"pinned": idMessage}},
+		ReturnNew: true,
+	}, &updated)
+
+	if err == mgo.ErrNotFound {
+		return fmt.Errorf("Unpin not possible, message %s is not pinned on topic %s", idMessage, topic.Topic)
+	} else if err != nil {
+		log.Errorf("Error while unpinning message %s on topic %s: %s", idMessage, topic.Topic, err)
+		return err
+	}
+	topic.Pinned = updated.Pinned
+	return topic.addToHistory(bson.M{"_id": topic.ID}, username, "unpin message "+idMessage)
+}
+
+// PinnedMessages returns messages pinned on topic, in order of pinned list
+func (topic *Topic) PinnedMessages() ([]Message, error) {
+	var messages []Message
+	if len(topic.Pinned) == 0 {
+		return messages, nil
+	}
+	err := Store().clMessages.Find(bson.M{"_id": bson.M{"$in": topic.Pinned}}).All(&messages)
+	if err != nil {
+		log.Errorf("Error while getting pinned messages of topic %s: %s", topic.Topic, err)
+		return messages, err
+	}
+
+	byID := make(map[string]Message, len(messages))
+	for _, m := range messages {
+		byID[m.ID] = m
+	}
+	pinned := make([]Message, 0, len(messages))
+	for _, id := range topic.Pinned {
+		if m, ok := byID[id]; ok {
+			pinned = append(pinned, m)
+		}
+	}
+	return pinned, nil
+}
+
+// unpinMessages removes messages matching selector from pinned messages
+// of topic, or of all topics if topic is empty
+func unpinMessages(selector bson.M, topic string) error {
+	var ids []string
+	if err := Store().clMessages.Find(selector).Distinct("_id", &ids); err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	topicsSelector := bson.M{"pinned": bson.M{"$in": ids}}
+	if topic != "" {
+		topicsSelector["topic"] = topic
+	}
+	_, err := Store().clTopics.UpdateAll(topicsSelector, bson.M{"$pull": bson.M{"pinned": bson.M{"$in": ids}}})
+	return err
+}
@@ -0,0 +1,308 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ovh/tat/utils"
+	"github.com/spf13/viper"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Scopes of rate limits on creation of messages
+const (
+	RateLimitScopeUser      = "user"
+	RateLimitScopeTopic     = "topic"
+	RateLimitScopeUserTopic = "userTopic"
+	RateLimitScopeHook      = "hook"
+)
+
+// RateLimitBucketsMaxAge is the age of an unused bucket before its removal
+const RateLimitBucketsMaxAge = time.Hour
+
+// maxRateLimitRetries is the max number of updates of a bucket updated
+// concurrently by others instances of Tat
+const maxRateLimitRetries = 5
+
+// RateLimitError is returned when a rate limit is reached, RetryAfter is in seconds
+type RateLimitError struct {
+	Scope      string
+	RetryAfter int64
+}
+
+func (e *RateLimitError) Error() string {
+	if e.Scope == "" {
+		return fmt.Sprintf("Rate limit reached, retry after %d seconds", e.RetryAfter)
+	}
+	return fmt.Sprintf("Rate limit by %s reached, retry after %d seconds", e.Scope, e.RetryAfter)
+}
+
+// RateLimitExceededError is returned when more messages are created at once
+// than allowed by minute by a rate limit: they are never allowed together
+type RateLimitExceededError struct {
+	Scope string
+	Rate  int
+	N     int
+}
+
+func (e *RateLimitExceededError) Error() string {
+	return fmt.Sprintf("Too many messages at once, %d, rate limit by %s is %d messages by minute", e.N, e.Scope, e.Rate)
+}
+
+// TopicRateLimit struct, rate limits of a topic, overriding rate limits of Tat:
+// PerTopic messages by minute on topic, PerUser messages by minute of a user
+// on topic. 0 is rate limit of Tat, -1 is no limit
+type TopicRateLimit struct {
+	PerTopic int `bson:"perTopic" json:"perTopic"`
+	PerUser  int `bson:"perUser"  json:"perUser"`
+}
+
+// RateLimitBucket struct, a token bucket stored in database, shared by all
+// instances of Tat. Counters are kept until bucket is removed, one hour after
+// its last use
+type RateLimitBucket struct {
+	ID         string    `bson:"_id"        json:"_id"`
+	Scope      string    `bson:"scope"      json:"scope"`
+	Username   string    `bson:"username"   json:"username,omitempty"`
+	Topic      string    `bson:"topic"      json:"topic,omitempty"`
+	Tokens     float64   `bson:"tokens"     json:"tokens"`
+	Last       int64     `bson:"last"       json:"-"`
+	DateLast   time.Time `bson:"dateLast"   json:"dateLast"`
+	NbAllowed  int64     `bson:"nbAllowed"  json:"nbAllowed"`
+	NbRejected int64     `bson:"nbRejected" json:"nbRejected"`
+}
+
+// RateLimitStats struct, counters of buckets of a scope
+type RateLimitStats struct {
+	Scope      string `bson:"_id"        json:"scope"`
+	Buckets    int64  `bson:"buckets"    json:"buckets"`
+	NbAllowed  int64  `bson:"nbAllowed"  json:"nbAllowed"`
+	NbRejected int64  `bson:"nbRejected" json:"nbRejected"`
+}
+
+// rateLimit is a rate limit checked on creation of messages, rate is a number of messages by minute
+type rateLimit struct {
+	scope    string
+	username string
+	topic    string
+	rate     int
+}
+
+func (l *rateLimit) key() string {
+	switch l.scope {
+	case RateLimitScopeUser, RateLimitScopeHook:
+		return l.scope + ":" + l.username
+	case RateLimitScopeTopic:
+		return l.scope + ":" + l.topic
+	}
+	return l.scope + ":" + l.username + ":" + l.topic
+}
+
+// messageRateLimits returns rate limits of user on topic, from flags of Tat,
+// with separate rate limits for system users, and from rate limits of topic
+func messageRateLimits(user User, topic Topic) []rateLimit {
+	perUser := viper.GetInt("rate_limit_user")
+	perUserTopic := viper.GetInt("rate_limit_user_topic")
+	if user.IsSystem {
+		perUser = viper.GetInt("rate_limit_system_user")
+		perUserTopic = viper.GetInt("rate_limit_system_user_topic")
+	}
+	perTopic := viper.GetInt("rate_limit_topic")
+
+	if topic.RateLimit != nil {
+		if topic.RateLimit.PerTopic != 0 {
+			perTopic = topic.RateLimit.PerTopic
+		}
+		if topic.RateLimit.PerUser != 0 {
+			perUserTopic = topic.RateLimit.PerUser
+		}
+	}
+	return []rateLimit{
+		{scope: RateLimitScopeUserTopic, username: user.Username, topic: topic.Topic, rate: perUserTopic},
+		{scope: RateLimitScopeUser, username: user.Username, rate: perUser},
+		{scope: RateLimitScopeTopic, topic: topic.Topic, rate: perTopic},
+	}
+}
+
+// TakeMessageTokens takes n tokens in buckets of user on topic, of user and of topic,
+// before creation of n messages. A RateLimitError is returned if a bucket has not
+// enough tokens, tokens taken in others buckets are given back
+func TakeMessageTokens(user User, topic Topic, n int) error {
+	return takeTokens(messageRateLimits(user, topic), n)
+}
+
+// TakeMessageTokensUpTo takes up to n tokens in buckets of user on topic, of user
+// and of topic, before creation of n messages, and returns the number of messages
+// allowed. A RateLimitError is returned if some messages are not allowed
+func TakeMessageTokensUpTo(user User, topic Topic, n int) (int, error) {
+	limits := messageRateLimits(user, topic)
+	taken := make([]int, len(limits))
+	allowed := n
+	var errRate error
+	for i := range limits {
+		var err error
+		if taken[i], err = limits[i].take(allowed, true); err != nil {
+			errRate = err
+		}
+		allowed = taken[i]
+	}
+	// tokens taken in a bucket for messages rejected by a next bucket are given back
+	for i := range limits {
+		if taken[i] > allowed {
+			limits[i].giveBack(taken[i] - allowed)
+		}
+	}
+	return allowed, errRate
+}
+
+// takeTokens takes n tokens in buckets of limits
+func takeTokens(limits []rateLimit, n int) error {
+	for i := range limits {
+		if _, err := limits[i].take(n, false); err != nil {
+			for _, taken := range limits[:i] {
+				taken.giveBack(n)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// take takes n tokens in bucket of rate limit, or up to n tokens if partial, and returns
+// the number of tokens taken. Bucket is updated only if it was not updated meanwhile by
+// another instance of Tat, and read again otherwise.
+// An error of database does not reject creation of messages
+func (l *rateLimit) take(n int, partial bool) (int, error) {
+	if l.rate <= 0 || n <= 0 {
+		return n, nil
+	}
+	if n > l.rate && !partial {
+		return 0, &RateLimitExceededError{Scope: l.scope, Rate: l.rate, N: n}
+	}
+	key := l.key()
+	for i := 0; i < maxRateLimitRetries; i++ {
+		var b RateLimitBucket
+		err := Store().clRateLimits.FindId(key).One(&b)
+		found := err == nil
+		if err != nil && err != mgo.ErrNotFound {
+			log.Errorf("Error while getting rate limit bucket %s: %s", key, err)
+			return n, nil
+		}
+
+		now := time.Now()
+		bucket := utils.TokenBucket{Tokens: b.Tokens, Last: b.Last}
+		taken, retryAfter := n, int64(0)
+		if partial {
+			taken, retryAfter = bucket.TakeUpTo(now.UnixNano()/int64(time.Millisecond), l.rate, n)
+		} else if ok, r := bucket.Take(now.UnixNano()/int64(time.Millisecond), l.rate, n); !ok {
+			taken, retryAfter = 0, r
+		}
+		var errRate error
+		if taken < n {
+			errRate = &RateLimitError{Scope: l.scope, RetryAfter: retryAfter}
+		}
+		if taken == 0 {
+			if found {
+				Store().clRateLimits.UpdateId(key, bson.M{"$inc": bson.M{"nbRejected": n}, "$set": bson.M{"dateLast": now}})
+			}
+			return 0, errRate
+		}
+
+		if !found {
+			err = Store().clRateLimits.Insert(&RateLimitBucket{
+				ID:         key,
+				Scope:      l.scope,
+				Username:   l.username,
+				Topic:      l.topic,
+				Tokens:     bucket.Tokens,
+				Last:       bucket.Last,
+				DateLast:   now,
+				NbAllowed:  int64(taken),
+				NbRejected: int64(n - taken),
+			})
+			if mgo.IsDup(err) {
+				continue
+			}
+		} else {
+			err = Store().clRateLimits.Update(
+				bson.M{"_id": key, "last": b.Last, "tokens": b.Tokens},
+				bson.M{"$set": bson.M{"tokens": bucket.Tokens, "last": bucket.Last, "dateLast": now},
+					"$inc": bson.M{"nbAllowed": taken, "nbRejected": n - taken}})
+			if err == mgo.ErrNotFound {
+				continue
+			}
+		}
+		if err != nil {
+			log.Errorf("Error while updating rate limit bucket %s: %s", key, err)
+		}
+		return taken, errRate
+	}
+	log.Warnf("Rate limit bucket %s not updated after %d retries", key, maxRateLimitRetries)
+	return n, nil
+}
+
+// giveBack gives back n tokens taken in bucket of rate limit
+func (l *rateLimit) giveBack(n int) {
+	if l.rate <= 0 {
+		return
+	}
+	err := Store().clRateLimits.UpdateId(l.key(), bson.M{"$inc": bson.M{"tokens": n, "nbAllowed": -n}})
+	if err != nil && err != mgo.ErrNotFound {
+		log.Errorf("Error while giving back tokens to rate limit bucket %s: %s", l.key(), err)
+	}
+}
+
+// SetRateLimit replaces rate limits of topic, a nil rate limit or
+// a rate limit with 0 values gives rate limits of Tat to topic
+func (topic *Topic) SetRateLimit(username string, rateLimit *TopicRateLimit) error {
+	update := bson.M{"$unset": bson.M{"rateLimit": ""}}
+	h := "remove rate limit"
+
+	if rateLimit != nil && (rateLimit.PerTopic != 0 || rateLimit.PerUser != 0) {
+		if rateLimit.PerTopic < -1 || rateLimit.PerUser < -1 {
+			return fmt.Errorf("Invalid rate limit, a number of messages by minute, 0 for rate limit of Tat or -1 for no limit, is expected")
+		}
+		update = bson.M{"$set": bson.M{"rateLimit": rateLimit}}
+		h = fmt.Sprintf("update rate limit to perTopic:%d, perUser:%d", rateLimit.PerTopic, rateLimit.PerUser)
+	} else {
+		rateLimit = nil
+	}
+
+	if err := Store().clTopics.Update(bson.M{"_id": topic.ID}, update); err != nil {
+		log.Errorf("Error while updating rate limit of topic %s: %s", topic.Topic, err)
+		return err
+	}
+	topic.RateLimit = rateLimit
+	return topic.addToHistory(bson.M{"_id": topic.ID}, username, h)
+}
+
+// GetRateLimitsStats returns counters of buckets by scope, and limit buckets
+// with most messages rejected
+func GetRateLimitsStats(limit int) ([]RateLimitStats, []RateLimitBucket, error) {
+	stats := []RateLimitStats{}
+	err := Store().clRateLimits.Pipe([]bson.M{
+		{"$group": bson.M{
+			"_id":        "$scope",
+			"buckets":    bson.M{"$sum": 1},
+			"nbAllowed":  bson.M{"$sum": "$nbAllowed"},
+			"nbRejected": bson.M{"$sum": "$nbRejected"},
+		}},
+		{"$sort": bson.M{"_id": 1}},
+	}).All(&stats)
+	if err != nil {
+		log.Errorf("Error while computing stats of rate limits: %s", err)
+		return stats, nil, err
+	}
+
+	buckets := []RateLimitBucket{}
+	err = Store().clRateLimits.Find(bson.M{"nbRejected": bson.M{"$gt": 0}}).
+		Sort("-nbRejected").
+		Limit(limit).
+		All(&buckets)
+	if err != nil {
+		log.Errorf("Error while listing buckets of rate limits: %s", err)
+	}
+	return stats, buckets, err
+}
@@ -0,0 +1,138 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ReactionLike is the reaction added by a like on a message
+const ReactionLike = "like"
+
+const lengthReaction = 32
+
+// Reaction struct, users who reacted to a message with same reaction code
+type Reaction struct {
+	Text      string   `bson:"text"      json:"text"`
+	Count     int64    `bson:"count"     json:"count"`
+	Usernames []string `bson:"usernames" json:"usernames"`
+}
+
+// CheckReaction returns an error if text is not a valid reaction code:
+// a non empty word of 32 characters max, as "like" or "+1"
+func CheckReaction(text string) error {
+	if text == "" || utf8.RuneCountInString(text) > lengthReaction || strings.ContainsAny(text, " \t\r\n") {
+		return fmt.Errorf("Invalid reaction %s, a reaction is a word of %d characters max", text, lengthReaction)
+	}
+	return nil
+}
+
+// React adds a reaction of user to a message
+func (message *Message) React(user User, text string) error {
+	if err := CheckReaction(text); err != nil {
+		return err
+	}
+
+	// like reaction is kept in sync with nbLikes and likers
+	isLike := text == ReactionLike
+
+	// two tries: reaction may be created by another user
+	// between update of an existing reaction and push of a new one
+	for i := 0; i < 2; i++ {
+		inc := bson.M{"reactions.$.count": 1}
+		push := bson.M{"reactions.$.usernames": user.Username}
+		if isLike {
+			inc["nbLikes"] = 1
+			push["likers"] = user.Username
+		}
+		err := Store().clMessages.Update(
+			bson.M{"_id": message.ID, "reactions": bson.M{"$elemMatch": bson.M{"text": text, "usernames": bson.M{"$ne": user.Username}}}},
+			bson.M{"$set": bson.M{"dateUpdate": time.Now().Unix()}, "$inc": inc, "$push": push})
+		if err == nil {
+			return message.FindByID(message.ID)
+		} else if err != mgo.ErrNotFound {
+			return err
+		}
+
+		n, err := Store().clMessages.Find(bson.M{"_id": message.ID, "reactions": bson.M{"$elemMatch": bson.M{"text": text, "usernames": user.Username}}}).Count()
+		if err != nil {
+			return err
+		} else if n > 0 {
+			return fmt.Errorf("React not possible, %s has already reacted %s to this message", user.Username, text)
+		}
+
+		push = bson.M{"reactions": Reaction{Text: text, Count: 1, Usernames: []string{user.Username}}}
+		update := bson.M{"$set": bson.M{"dateUpdate": time.Now().Unix()}, "$push": push}
+		if isLike {
+			push["likers"] = user.Username
+			update["$inc"] = bson.M{"nbLikes": 1}
+		}
+		err = Store().clMessages.Update(bson.M{"_id": message.ID, "reactions.text": bson.M{"$ne": text}}, update)
+		if err == nil {
+			return message.FindByID(message.ID)
+		} else if err != mgo.ErrNotFound {
+			return err
+		}
+	}
+	return fmt.Errorf("React not possible, message %s does not exist or is updated concurrently", message.ID)
+}
+
+// Unreact removes a reaction of user from a message
+func (message *Message) Unreact(user User, text string) error {
+	inc := bson.M{"reactions.$.count": -1}
+	pull := bson.M{"reactions.$.usernames": user.Username}
+	if text == ReactionLike {
+		inc["nbLikes"] = -1
+		pull["likers"] = user.Username
+	}
+
+	err := Store().clMessages.Update(
+		bson.M{"_id": message.ID, "reactions": bson.M{"$elemMatch": bson.M{"text": text, "usernames": user.Username}}},
+		bson.M{"$set": bson.M{"dateUpdate": time.Now().Unix()}, "$inc": inc, "$pull": pull})
+	if err == mgo.ErrNotFound {
+		return fmt.Errorf("Unreact not possible, %s has not reacted %s to this message", user.Username, text)
+	} else if err != nil {
+		return err
+	}
+
+	// removes reaction without any user
+	err = Store().clMessages.Update(
+		bson.M{"_id": message.ID},
+		bson.M{"$pull": bson.M{"reactions": bson.M{"count": bson.M{"$lte": 0}}}})
+	if err != nil {
+		return err
+	}
+	return message.FindByID(message.ID)
+}
+
+// migrateLikesToReactions adds a "like" reaction on messages
+// liked before reactions
+func migrateLikesToReactions() error {
+	var message Message
+	iter := Store().clMessages.Find(bson.M{
+		"likers.0":       bson.M{"$exists": true},
+		"reactions.text": bson.M{"$ne": ReactionLike},
+	}).Iter()
+
+	nb := 0
+	for iter.Next(&message) {
+		err := Store().clMessages.Update(
+			bson.M{"_id": message.ID, "reactions.text": bson.M{"$ne": ReactionLike}},
+			bson.M{"$push": bson.M{"reactions": Reaction{Text: ReactionLike, Count: int64(len(message.Likers)), Usernames: message.Likers}}})
+		if err != nil && err != mgo.ErrNotFound {
+			log.Errorf("Error while migrating likes of message %s to reactions: %s", message.ID, err)
+			continue
+		}
+		nb++
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+	log.Infof("%d messages migrated from likes to reactions", nb)
+	return nil
+}
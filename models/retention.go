@@ -0,0 +1,221 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// retentionUsername is the author of history entries written by purger
+const retentionUsername = "tat.retention"
+
+// threads are purged by batch of purgeBatchSize root messages
+const purgeBatchSize = 500
+
+// SetRetention updates retention on topic: messages older than maxAge days
+// are purged, and only maxCount newest threads are kept. 0 is no limit
+func (topic *Topic) SetRetention(username string, recursive bool, maxAge, maxCount int) error {
+	if maxAge < 0 || maxCount < 0 {
+		return fmt.Errorf("Invalid retention, maxAge and maxCount must be positive or 0")
+	}
+
+	var selector bson.M
+	if recursive {
+		selector = bson.M{"topic": bson.RegEx{Pattern: "^" + topic.Topic + ".*$"}}
+	} else {
+		selector = bson.M{"_id": topic.ID}
+	}
+
+	_, err := Store().clTopics.UpdateAll(selector, bson.M{"$set": bson.M{
+		"retentionMaxAge":   maxAge,
+		"retentionMaxCount": maxCount,
+	}})
+	if err != nil {
+		log.Errorf("Error while updateAll retention : %s", err.Error())
+		return err
+	}
+	h := fmt.Sprintf("update retention to maxAge:%d days, maxCount:%d", maxAge, maxCount)
+	return topic.addToHistory(selector, username, h)
+}
+
+// PurgeTopics purges messages of topics with a retention, every period seconds.
+// Each topic is claimed before purge, so that many tat instances can run it
+func PurgeTopics(period int) {
+	if period <= 0 {
+		log.Warnf("Purge of topics with retention is disabled")
+		return
+	}
+	for range time.Tick(time.Duration(period) * time.Second) {
+		for {
+			var topic = Topic{}
+			now := time.Now().Unix()
+			_, err := Store().clTopics.Find(bson.M{
+				"$or": []bson.M{
+					bson.M{"retentionMaxAge": bson.M{"$gt": 0}},
+					bson.M{"retentionMaxCount": bson.M{"$gt": 0}},
+				},
+				"dateLastPurge": bson.M{"$not": bson.M{"$gt": now - int64(period)}},
+			}).Apply(mgo.Change{
+				Update:    bson.M{"$set": bson.M{"dateLastPurge": now}},
+				ReturnNew: true,
+			}, &topic)
+
+			if err == mgo.ErrNotFound {
+				break
+			} else if err != nil {
+				log.Errorf("Error while claiming a topic to purge: %s", err)
+				break
+			}
+			if err := topic.purge(); err != nil {
+				log.Errorf("Error while purging topic %s: %s", topic.Topic, err)
+			}
+		}
+	}
+}
+
+// purge removes expired threads of topic, with their replies, revisions and pins.
+// A thread in a Tasks topic is never purged, nor a thread with a reply
+// younger than retentionMaxAge. A thread shared on topic is unshared from it,
+// and kept on its original topic
+func (topic *Topic) purge() error {
+	rootsSelector := bson.M{
+		"topics":          topic.Topic,
+		"inReplyOfIDRoot": "",
+	}
+	var expired []string
+
+	if topic.RetentionMaxAge > 0 {
+		dateMin := time.Now().Unix() - int64(topic.RetentionMaxAge)*24*3600
+		var ids []string
+		selector := bson.M{"dateCreation": bson.M{"$lt": dateMin}}
+		for k, v := range rootsSelector {
+			selector[k] = v
+		}
+		if err := Store().clMessages.Find(selector).Distinct("_id", &ids); err != nil {
+			return err
+		}
+
+		var active []string
+		for i := 0; i < len(ids); i += purgeBatchSize {
+			var activeBatch []string
+			err := Store().clMessages.Find(bson.M{
+				"inReplyOfIDRoot": bson.M{"$in": ids[i:minInt(i+purgeBatchSize, len(ids))]},
+				"dateCreation":    bson.M{"$gte": dateMin},
+			}).Distinct("inReplyOfIDRoot", &activeBatch)
+			if err != nil {
+				return err
+			}
+			active = append(active, activeBatch...)
+		}
+		expired = append(expired, substractIDs(ids, active)...)
+	}
+
+	if topic.RetentionMaxCount > 0 {
+		var roots []Message
+		err := Store().clMessages.Find(rootsSelector).
+			Select(bson.M{"_id": 1}).
+			Sort("-dateCreation").
+			Skip(topic.RetentionMaxCount).
+			All(&roots)
+		if err != nil {
+			return err
+		}
+		var ids []string
+		for _, m := range roots {
+			ids = append(ids, m.ID)
+		}
+		expired = append(expired, substractIDs(ids, expired)...)
+	}
+
+	if len(expired) == 0 {
+		return nil
+	}
+
+	nbThreads, nbMessages, nbShared := 0, 0, 0
+	for i := 0; i < len(expired); i += purgeBatchSize {
+		batch := expired[i:minInt(i+purgeBatchSize, len(expired))]
+
+		// original topic of a message is its first topic
+		var shared []string
+		err := Store().clMessages.Find(bson.M{
+			"_id":      bson.M{"$in": batch},
+			"topics.0": bson.M{"$ne": topic.Topic},
+		}).Distinct("_id", &shared)
+		if err != nil {
+			return err
+		}
+		if len(shared) > 0 {
+			selector := bson.M{"$or": []bson.M{
+				bson.M{"_id": bson.M{"$in": shared}},
+				bson.M{"inReplyOfIDRoot": bson.M{"$in": shared}},
+			}}
+			if err := unshareMessages(selector, topic.Topic); err != nil {
+				return err
+			}
+			nbShared += len(shared)
+		}
+
+		// messages in a Tasks topic are kept, task is on whole thread
+		var ids []string
+		err = Store().clMessages.Find(bson.M{
+			"_id":    bson.M{"$in": substractIDs(batch, shared)},
+			"topics": bson.M{"$not": bson.RegEx{Pattern: "^/Private/[^/]+/Tasks"}},
+		}).Distinct("_id", &ids)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		selector := bson.M{"$or": []bson.M{
+			bson.M{"_id": bson.M{"$in": ids}},
+			bson.M{"inReplyOfIDRoot": bson.M{"$in": ids}},
+		}}
+		if err := removeRevisions(selector); err != nil {
+			return err
+		}
+		if err := unpinMessages(selector, ""); err != nil {
+			return err
+		}
+		info, err := Store().clMessages.RemoveAll(selector)
+		if err != nil {
+			return err
+		}
+		nbThreads += len(ids)
+		nbMessages += info.Removed
+	}
+
+	if nbMessages == 0 && nbShared == 0 {
+		return nil
+	}
+	log.Infof("Topic %s purged: %d messages in %d threads, %d shared threads unshared", topic.Topic, nbMessages, nbThreads, nbShared)
+	h := fmt.Sprintf("purge %d messages in %d threads, unshare %d threads, retention maxAge:%d days, maxCount:%d",
+		nbMessages, nbThreads, nbShared, topic.RetentionMaxAge, topic.RetentionMaxCount)
+	return topic.addToHistory(bson.M{"_id": topic.ID}, retentionUsername, h)
+}
+
+// substractIDs returns ids not in toRemove
+func substractIDs(ids, toRemove []string) []string {
+	removed := make(map[string]bool, len(toRemove))
+	for _, id := range toRemove {
+		removed[id] = true
+	}
+	var result []string
+	for _, id := range ids {
+		if !removed[id] {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Revision struct, a previous text of a message.
+// Author is the user who replaced this text, on DateRevision
+type Revision struct {
+	ID           string `bson:"_id"          json:"_id"`
+	IDMessage    string `bson:"idMessage"    json:"idMessage"`
+	Revision     int    `bson:"revision"     json:"revision"`
+	Text         string `bson:"text"         json:"text"`
+	Author       Author `bson:"author"       json:"author"`
+	DateRevision int64  `bson:"dateRevision" json:"dateRevision"`
+}
+
+// ListRevisions returns all revisions of a message, oldest first
+func ListRevisions(idMessage string) ([]Revision, error) {
+	var revisions []Revision
+	err := Store().clRevisions.Find(bson.M{"idMessage": idMessage}).
+		Sort("revision").
+		All(&revisions)
+	if err != nil {
+		log.Errorf("Error while getting revisions of message %s: %s", idMessage, err)
+	}
+	return revisions, err
+}
+
+// GetRevisionText returns text of a message for given revision number.
+// Revision number nbRevisions of message is its current text
+func (message *Message) GetRevisionText(revision int) (string, error) {
+	if revision < 0 || revision > message.NbRevisions {
+		return "", fmt.Errorf("Invalid revision %d, message %s has %d revisions", revision, message.ID, message.NbRevisions)
+	}
+	if revision == message.NbRevisions {
+		return message.Text, nil
+	}
+
+	var r = Revision{}
+	err := Store().clRevisions.Find(bson.M{"idMessage": message.ID, "revision": revision}).One(&r)
+	if err != nil {
+		log.Errorf("Error while getting revision %d of message %s: %s", revision, message.ID, err)
+		return "", err
+	}
+	return r.Text, nil
+}
+
+func (revision *Revision) insert() error {
+	revision.ID = bson.NewObjectId().Hex()
+	err := Store().clRevisions.Insert(revision)
+	if err != nil {
+		log.Errorf("Error while inserting revision %d of message %s: %s", revision.Revision, revision.IDMessage, err)
+	}
+	return err
+}
+
+// removeRevisions removes revisions of messages matching selector
+func removeRevisions(selector bson.M) error {
+	var ids []string
+	err := Store().clMessages.Find(selector).Distinct("_id", &ids)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err = Store().clRevisions.RemoveAll(bson.M{"idMessage": bson.M{"$in": ids}})
+	return err
+}
+
+func changeAuthorUsernameOnRevisions(oldUsername, newUsername string) error {
+	_, err := Store().clRevisions.UpdateAll(
+		bson.M{"author.username": oldUsername},
+		bson.M{"$set": bson.M{"author.username": newUsername}})
+
+	if err != nil {
+		log.Errorf("Error while update username from %s to %s on Revisions %s", oldUsername, newUsername, err)
+	}
+
+	return err
+}
@@ -0,0 +1,313 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ovh/tat/utils"
+	"github.com/yesnault/hashtag"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MaxTopicRules is the max number of rules on a topic
+const MaxTopicRules = 50
+
+// MaxRulesDryRun is the max number of messages checked by a dry run of rules
+const MaxRulesDryRun = 500
+
+// a mention of a rule is a username or a group name, without @
+var ruleMentionRegexp = regexp.MustCompile(`^[\w.\-]+$`)
+
+// maxRuleRegexps is the max number of compiled regex of rules kept in ruleRegexps
+const maxRuleRegexps = 10000
+
+// key regex of a rule, compiled regex, nil if invalid
+var ruleRegexps = struct {
+	sync.RWMutex
+	m map[string]*regexp.Regexp
+}{m: make(map[string]*regexp.Regexp)}
+
+// TopicRule struct, a rule applied on new messages of a topic. Text, Author and
+// Tag are regex, on text, on username of author and on tags of message: a rule
+// matches if all its given regex match. Actions of a matching rule add Labels and
+// Tags, mention users or groups of Mentions, and move message to sub-topic MoveTo.
+// Rules are applied in order, a matching rule with Stop ends rules
+type TopicRule struct {
+	Name     string   `bson:"name"     json:"name,omitempty"`
+	Text     string   `bson:"text"     json:"text,omitempty"`
+	Author   string   `bson:"author"   json:"author,omitempty"`
+	Tag      string   `bson:"tag"      json:"tag,omitempty"`
+	Labels   []Label  `bson:"labels"   json:"labels,omitempty"`
+	Tags     []string `bson:"tags"     json:"tags,omitempty"`
+	Mentions []string `bson:"mentions" json:"mentions,omitempty"`
+	MoveTo   string   `bson:"moveTo"   json:"moveTo,omitempty"`
+	Stop     bool     `bson:"stop"     json:"stop,omitempty"`
+
+	// compiled regex of rule, got on first match of rule
+	compiled     bool
+	textRegexp   *regexp.Regexp
+	authorRegexp *regexp.Regexp
+	tagRegexp    *regexp.Regexp
+}
+
+// RulesResult struct, rules matching a message, and their actions
+type RulesResult struct {
+	IDMessage string   `json:"idMessage,omitempty"`
+	Text      string   `json:"text,omitempty"`
+	Rules     []int    `json:"rules"`
+	Labels    []string `json:"labels,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Mentions  []string `json:"mentions,omitempty"`
+	MoveTo    string   `json:"moveTo,omitempty"`
+}
+
+// checkRule checks regex and actions of rule, on topic
+func (topic *Topic) checkRule(rule TopicRule) error {
+	if rule.Text == "" && rule.Author == "" && rule.Tag == "" {
+		return fmt.Errorf("at least one of text, author or tag is expected")
+	}
+	for _, r := range []string{rule.Text, rule.Author, rule.Tag} {
+		if _, err := regexp.Compile(r); err != nil {
+			return fmt.Errorf("invalid regex %s: %s", r, err)
+		}
+	}
+	if len(rule.Labels) == 0 && len(rule.Tags) == 0 && len(rule.Mentions) == 0 && rule.MoveTo == "" {
+		return fmt.Errorf("at least one action of labels, tags, mentions or moveTo is expected")
+	}
+	for _, l := range rule.Labels {
+		if l.Text == "" || len(l.Text) > lengthLabel {
+			return fmt.Errorf("invalid label %s, text of a label is %d characters max and can't be empty", l.Text, lengthLabel)
+		}
+		if l.Color != "" && !labelColorRegexp.MatchString(l.Color) {
+			return fmt.Errorf("invalid color %s for label %s", l.Color, l.Text)
+		}
+	}
+	if _, err := topic.CheckCatalogLabels(rule.Labels); err != nil {
+		return err
+	}
+	for _, tag := range rule.Tags {
+		if strings.TrimSpace(tag) == "" {
+			return fmt.Errorf("invalid empty tag")
+		}
+	}
+	for _, mention := range rule.Mentions {
+		if !ruleMentionRegexp.MatchString(mention) {
+			return fmt.Errorf("invalid mention %s, a username or a group name without @ is expected", mention)
+		}
+	}
+	if rule.MoveTo != "" {
+		if !strings.HasPrefix(rule.MoveTo, topic.Topic+"/") {
+			return fmt.Errorf("invalid moveTo %s, a sub-topic of %s is expected", rule.MoveTo, topic.Topic)
+		}
+		if !IsTopicExists(rule.MoveTo) {
+			return fmt.Errorf("invalid moveTo, topic %s does not exist", rule.MoveTo)
+		}
+	}
+	return nil
+}
+
+// SetRules replaces rules of topic
+func (topic *Topic) SetRules(username string, rules []TopicRule) error {
+	if len(rules) > MaxTopicRules {
+		return fmt.Errorf("Invalid rules, %d rules max", MaxTopicRules)
+	}
+	for i, rule := range rules {
+		if err := topic.checkRule(rule); err != nil {
+			return fmt.Errorf("Invalid rule %d: %s", i, err)
+		}
+	}
+	if rules == nil {
+		rules = []TopicRule{}
+	}
+
+	err := Store().clTopics.Update(bson.M{"_id": topic.ID}, bson.M{"$set": bson.M{"rules": rules}})
+	if err != nil {
+		log.Errorf("Error while updating rules of topic %s: %s", topic.Topic, err)
+		return err
+	}
+	topic.Rules = rules
+	h := fmt.Sprintf("update rules to %d rules", len(rules))
+	return topic.addToHistory(bson.M{"_id": topic.ID}, username, h)
+}
+
+// match returns true if all regex of rule match message
+func (rule *TopicRule) match(message *Message) bool {
+	if !rule.compiled {
+		rule.compile()
+	}
+	if rule.Text != "" && !ruleRegexpMatch(rule.textRegexp, message.Text) {
+		return false
+	}
+	if rule.Author != "" && !ruleRegexpMatch(rule.authorRegexp, message.Author.Username) {
+		return false
+	}
+	if rule.Tag != "" {
+		for _, tag := range message.Tags {
+			if ruleRegexpMatch(rule.tagRegexp, tag) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// compile gets compiled regex of rule, for all messages matched with rule
+func (rule *TopicRule) compile() {
+	rule.textRegexp = compileRuleRegexp(rule.Text)
+	rule.authorRegexp = compileRuleRegexp(rule.Author)
+	rule.tagRegexp = compileRuleRegexp(rule.Tag)
+	rule.compiled = true
+}
+
+// compileRuleRegexp returns regex r compiled, compiled once for all rules with r.
+// Regex of rules are checked on update of rules, an invalid regex is nil
+func compileRuleRegexp(r string) *regexp.Regexp {
+	ruleRegexps.RLock()
+	re, ok := ruleRegexps.m[r]
+	ruleRegexps.RUnlock()
+	if ok {
+		return re
+	}
+
+	re, _ = regexp.Compile(r)
+	ruleRegexps.Lock()
+	if len(ruleRegexps.m) >= maxRuleRegexps {
+		ruleRegexps.m = make(map[string]*regexp.Regexp)
+	}
+	ruleRegexps.m[r] = re
+	ruleRegexps.Unlock()
+	return re
+}
+
+// ruleRegexpMatch returns true if s matches regex re, a nil regex matches nothing
+func ruleRegexpMatch(re *regexp.Regexp, s string) bool {
+	return re != nil && re.MatchString(s)
+}
+
+// regexpMatch returns true if s matches regex r, an invalid regex matches nothing
+func regexpMatch(r, s string) bool {
+	re, err := regexp.Compile(r)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(s)
+}
+
+// applyRules applies rules of topic on a new message, before its insert.
+// Labels are checked with labels catalog of topic, mentions are added to
+// mentions of message, text of message is not updated
+func (message *Message) applyRules(topic Topic, rules []TopicRule) RulesResult {
+	result := RulesResult{Rules: []int{}}
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.match(message) {
+			continue
+		}
+		result.Rules = append(result.Rules, i)
+
+		var labels []Label
+		for _, l := range rule.Labels {
+			if !message.ContainsLabel(l.Text) {
+				labels = append(labels, l)
+			}
+		}
+		if checked, err := topic.CheckCatalogLabels(append(message.Labels, labels...)); err == nil {
+			message.Labels = checked
+			for _, l := range labels {
+				result.Labels = append(result.Labels, l.Text)
+			}
+		} else {
+			log.Warnf("Labels of rule %d of topic %s not added: %s", i, topic.Topic, err)
+		}
+
+		for _, tag := range rule.Tags {
+			if !message.containsTag(tag) {
+				message.Tags = append(message.Tags, tag)
+				result.Tags = append(result.Tags, tag)
+			}
+		}
+		for _, mention := range rule.Mentions {
+			if !utils.ArrayContains(hashtag.ExtractMentions(message.Text), mention) && !utils.ArrayContains(message.ruleMentions, mention) {
+				message.ruleMentions = append(message.ruleMentions, mention)
+				result.Mentions = append(result.Mentions, mention)
+			}
+		}
+		if rule.MoveTo != "" && len(message.Topics) > 0 {
+			message.Topics[0] = rule.MoveTo
+			result.MoveTo = rule.MoveTo
+		}
+		if rule.Stop {
+			break
+		}
+	}
+	return result
+}
+
+// moveToRuleTopic returns topic of a message moved by a rule: message is checked
+// again on it, with its external keys, validation on authorTags, its labels catalog
+// and its rate limits by topic
+func (message *Message) moveToRuleTopic(user User, authorTags []string) (Topic, error) {
+	var topic = Topic{}
+	if err := topic.FindByTopic(message.Topics[0], true); err != nil {
+		return topic, fmt.Errorf("Topic %s of moveTo of a rule does not exist", message.Topics[0])
+	}
+	if err := message.checkExternalKey(topic.Topic); err != nil {
+		return topic, err
+	}
+	if err := message.checkValidation(topic, authorTags); err != nil {
+		return topic, err
+	}
+	labels, err := topic.CheckCatalogLabels(message.Labels)
+	if err != nil {
+		return topic, err
+	}
+	message.Labels = labels
+
+	// rate limit of user on all topics is already checked
+	var limits []rateLimit
+	for _, l := range messageRateLimits(user, topic) {
+		if l.scope != RateLimitScopeUser {
+			limits = append(limits, l)
+		}
+	}
+	return topic, takeTokens(limits, 1)
+}
+
+// DryRunRules applies rules on limit newest messages of topic, without updating them,
+// and returns messages matching at least one rule
+func (topic *Topic) DryRunRules(rules []TopicRule, limit int) ([]RulesResult, error) {
+	for i, rule := range rules {
+		if err := topic.checkRule(rule); err != nil {
+			return nil, fmt.Errorf("Invalid rule %d: %s", i, err)
+		}
+	}
+	if limit <= 0 || limit > MaxRulesDryRun {
+		limit = MaxRulesDryRun
+	}
+
+	var messages []Message
+	err := Store().clMessages.Find(bson.M{"topics": topic.Topic, "inReplyOfIDRoot": ""}).
+		Sort("-dateCreation", "-_id").
+		Limit(limit).
+		All(&messages)
+	if err != nil {
+		log.Errorf("Error while listing messages of topic %s for dry run of rules: %s", topic.Topic, err)
+		return nil, err
+	}
+
+	results := []RulesResult{}
+	for i := range messages {
+		text := messages[i].Text
+		result := messages[i].applyRules(*topic, rules)
+		if len(result.Rules) > 0 {
+			result.IDMessage = messages[i].ID
+			result.Text = text
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,343 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ovh/tat/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// ScheduledPending is status of a scheduled message waiting for its datePublish
+	ScheduledPending = "pending"
+	// ScheduledPublishing is status of a scheduled message being published
+	ScheduledPublishing = "publishing"
+	// ScheduledError is status of a scheduled message which could not be published
+	ScheduledError = "error"
+	// ScheduledPublished is status of a scheduled message published, before its removal
+	ScheduledPublished = "published"
+)
+
+// a publishing scheduled message is claimed again after this delay,
+// if instance publishing it has stopped
+const scheduledClaimTimeout = 300
+
+// ScheduledMessage struct, a message to publish on DatePublish.
+// Only its author can see it before publication
+type ScheduledMessage struct {
+	ID           string  `bson:"_id"          json:"_id"`
+	Topic        string  `bson:"topic"        json:"topic"`
+	Text         string  `bson:"text"         json:"text"`
+	InReplyOfID  string  `bson:"inReplyOfID"  json:"inReplyOfID"`
+	Labels       []Label `bson:"labels"       json:"labels,omitempty"`
+	Author       Author  `bson:"author"       json:"author"`
+	DatePublish  int64   `bson:"datePublish"  json:"datePublish"`
+	DateCreation int64   `bson:"dateCreation" json:"dateCreation"`
+	DateUpdate   int64   `bson:"dateUpdate"   json:"dateUpdate"`
+	DateClaim    int64   `bson:"dateClaim"    json:"-"`
+	IDMessage    string  `bson:"idMessage"    json:"-"`
+	Status       string  `bson:"status"       json:"status"`
+	Error        string  `bson:"error"        json:"error,omitempty"`
+}
+
+// ScheduledMessageCriteria are used to list scheduled messages
+type ScheduledMessageCriteria struct {
+	Skip     int
+	Limit    int
+	Username string
+	Topic    string
+	Status   string
+}
+
+func checkDatePublish(datePublish int64) error {
+	if datePublish <= time.Now().Unix() {
+		return fmt.Errorf("Invalid datePublish %d, it must be in the future", datePublish)
+	}
+	return nil
+}
+
+// Insert a new scheduled message, to publish on topic with user as author
+func (scheduled *ScheduledMessage) Insert(user User, topic Topic, text, inReplyOfID string, datePublish int64, labels []Label) error {
+	if err := checkDatePublish(datePublish); err != nil {
+		return err
+	}
+	if text == "" {
+		return fmt.Errorf("Invalid text, text can't be empty")
+	}
+	scheduled.InReplyOfID = inReplyOfID
+	if err := scheduled.check(user, topic, text, labels); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	scheduled.ID = bson.NewObjectId().Hex()
+	scheduled.Topic = topic.Topic
+	scheduled.Text = text
+	scheduled.Labels = labels
+	scheduled.Author = Author{Username: user.Username, Fullname: user.Fullname}
+	scheduled.DatePublish = datePublish
+	scheduled.DateCreation = now
+	scheduled.DateUpdate = now
+	scheduled.Status = ScheduledPending
+
+	err := Store().clScheduledMessages.Insert(scheduled)
+	if err != nil {
+		log.Errorf("Error while inserting scheduled message for topic %s: %s", topic.Topic, err)
+	}
+	return err
+}
+
+// check checks text and labels of a scheduled message as on insert of a message:
+// message replied on topic, labels catalog, validation and mentions of topic.
+// Rules of topic are applied on publication
+func (scheduled *ScheduledMessage) check(user User, topic Topic, text string, labels []Label) error {
+	var reference *Message
+	if scheduled.InReplyOfID != "" {
+		reference = &Message{}
+		if err := reference.FindByID(scheduled.InReplyOfID); err != nil || !utils.ArrayContains(reference.Topics, topic.Topic) {
+			return fmt.Errorf("Message %s does not exist on topic %s", scheduled.InReplyOfID, topic.Topic)
+		}
+	}
+	// rules could move message and take tokens of rate limits of a sub-topic
+	topic.Rules = nil
+	message := Message{}
+	return message.prepare(user, topic, text, scheduled.InReplyOfID, -1, labels, false, reference)
+}
+
+// FindByIDAndUsername returns a scheduled message of user
+func (scheduled *ScheduledMessage) FindByIDAndUsername(id, username string) error {
+	err := Store().clScheduledMessages.Find(bson.M{"_id": id, "author.username": username}).One(&scheduled)
+	if err != nil {
+		log.Errorf("Error while fetching scheduled message %s of user %s: %s", id, username, err)
+	}
+	return err
+}
+
+// ListScheduledMessages returns scheduled messages matching criteria, next to publish first
+func ListScheduledMessages(criteria *ScheduledMessageCriteria) (int, []ScheduledMessage, error) {
+	var scheduled []ScheduledMessage
+
+	query := bson.M{"author.username": criteria.Username}
+	if criteria.Topic != "" {
+		query["topic"] = criteria.Topic
+	}
+	if criteria.Status != "" {
+		query["status"] = criteria.Status
+	} else {
+		query["status"] = bson.M{"$ne": ScheduledPublished}
+	}
+
+	cursor := Store().clScheduledMessages.Find(query)
+	count, err := cursor.Count()
+	if err != nil {
+		log.Errorf("Error while Count Scheduled Messages %s", err)
+		return count, scheduled, err
+	}
+
+	err = cursor.Sort("datePublish").
+		Skip(criteria.Skip).
+		Limit(criteria.Limit).
+		All(&scheduled)
+
+	if err != nil {
+		log.Errorf("Error while Find All Scheduled Messages %s", err)
+	}
+	return count, scheduled, err
+}
+
+// Update text, datePublish and labels of a scheduled message not yet published, of user.
+// A scheduled message in error is pending again after update
+func (scheduled *ScheduledMessage) Update(user User, text string, datePublish int64, labels []Label) error {
+	if text != "" || labels != nil {
+		topic := Topic{}
+		if err := topic.FindByTopic(scheduled.Topic, true); err != nil {
+			return fmt.Errorf("Topic %s does not exist", scheduled.Topic)
+		}
+		newText, newLabels := scheduled.Text, scheduled.Labels
+		if text != "" {
+			newText = text
+		}
+		if labels != nil {
+			newLabels = labels
+		}
+		if err := scheduled.check(user, topic, newText, newLabels); err != nil {
+			return err
+		}
+	}
+
+	set := bson.M{"dateUpdate": time.Now().Unix(), "status": ScheduledPending, "error": ""}
+	if text != "" {
+		set["text"] = text
+	}
+	if datePublish > 0 {
+		if err := checkDatePublish(datePublish); err != nil {
+			return err
+		}
+		set["datePublish"] = datePublish
+	}
+	if labels != nil {
+		set["labels"] = labels
+	}
+
+	_, err := Store().clScheduledMessages.Find(bson.M{
+		"_id":    scheduled.ID,
+		"status": bson.M{"$in": []string{ScheduledPending, ScheduledError}},
+	}).Apply(mgo.Change{Update: bson.M{"$set": set}, ReturnNew: true}, scheduled)
+
+	if err == mgo.ErrNotFound {
+		return fmt.Errorf("Scheduled message %s is being published, it can't be updated", scheduled.ID)
+	} else if err != nil {
+		log.Errorf("Error while updating scheduled message %s: %s", scheduled.ID, err)
+	}
+	return err
+}
+
+// Delete cancels a scheduled message not yet published
+func (scheduled *ScheduledMessage) Delete() error {
+	err := Store().clScheduledMessages.Remove(bson.M{
+		"_id":    scheduled.ID,
+		"status": bson.M{"$in": []string{ScheduledPending, ScheduledError}},
+	})
+	if err == mgo.ErrNotFound {
+		return fmt.Errorf("Scheduled message %s is being published, it can't be deleted", scheduled.ID)
+	}
+	return err
+}
+
+// PublishScheduledMessages publishes due scheduled messages, every period seconds.
+// Each scheduled message is claimed before publication, so that many tat
+// instances can run it
+func PublishScheduledMessages(period int) {
+	if period <= 0 {
+		log.Warnf("Publication of scheduled messages is disabled")
+		return
+	}
+	for range time.Tick(time.Duration(period) * time.Second) {
+		// published scheduled messages not removed on their publication
+		if _, err := Store().clScheduledMessages.RemoveAll(bson.M{"status": ScheduledPublished}); err != nil {
+			log.Errorf("Error while removing published scheduled messages: %s", err)
+		}
+		for {
+			scheduled, err := claimScheduledMessage()
+			if err == mgo.ErrNotFound {
+				break
+			} else if err != nil {
+				log.Errorf("Error while claiming a scheduled message: %s", err)
+				break
+			}
+			scheduled.publish()
+		}
+	}
+}
+
+func claimScheduledMessage() (ScheduledMessage, error) {
+	var scheduled ScheduledMessage
+	now := time.Now().Unix()
+	_, err := Store().clScheduledMessages.Find(bson.M{
+		"datePublish": bson.M{"$lte": now},
+		"$or": []bson.M{
+			bson.M{"status": ScheduledPending},
+			bson.M{"status": ScheduledPublishing, "dateClaim": bson.M{"$lt": now - scheduledClaimTimeout}},
+		},
+	}).Sort("datePublish").Apply(mgo.Change{
+		Update:    bson.M{"$set": bson.M{"status": ScheduledPublishing, "dateClaim": now}},
+		ReturnNew: true,
+	}, &scheduled)
+	return scheduled, err
+}
+
+// setIDMessage sets id of message published by scheduled message, once: a scheduled
+// message claimed again after a failure of its publication keeps same id of message
+func (scheduled *ScheduledMessage) setIDMessage() error {
+	if scheduled.IDMessage != "" {
+		return nil
+	}
+	err := Store().clScheduledMessages.Update(
+		bson.M{"_id": scheduled.ID, "idMessage": bson.M{"$in": []interface{}{"", nil}}},
+		bson.M{"$set": bson.M{"idMessage": bson.NewObjectId().Hex()}})
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	return Store().clScheduledMessages.FindId(scheduled.ID).One(scheduled)
+}
+
+// publish inserts message, rights of author are checked again
+// as they could have changed since scheduling. Message is inserted with
+// id IDMessage of scheduled message, a message already inserted by
+// a previous publication is not inserted again
+func (scheduled *ScheduledMessage) publish() {
+	var user = User{}
+	var topic = Topic{}
+	var message = Message{}
+
+	err := scheduled.setIDMessage()
+	if err == nil {
+		err = user.FindByUsername(scheduled.Author.Username)
+	}
+	if err == nil {
+		err = topic.FindByTopic(scheduled.Topic, true)
+	}
+	if err == nil && !topic.IsUserRW(&user) {
+		err = fmt.Errorf("No RW Access to topic %s", scheduled.Topic)
+	}
+	if err == nil && scheduled.InReplyOfID != "" {
+		// thread replied could have been moved to another topic since schedule
+		var reference = Message{}
+		if reference.FindByID(scheduled.InReplyOfID) != nil || !utils.ArrayContains(reference.Topics, topic.Topic) {
+			err = fmt.Errorf("Message %s does not exist on topic %s", scheduled.InReplyOfID, topic.Topic)
+		}
+	}
+	if err == nil {
+		err = Store().clMessages.FindId(scheduled.IDMessage).One(&message)
+		if err == mgo.ErrNotFound {
+			message = Message{ID: scheduled.IDMessage}
+			err = message.Insert(user, topic, scheduled.Text, scheduled.InReplyOfID, -1, scheduled.Labels, false)
+			if mgo.IsDup(err) && Store().clMessages.FindId(scheduled.IDMessage).One(&message) == nil {
+				// inserted meanwhile by another publication
+				err = nil
+			}
+		}
+	}
+
+	if err != nil {
+		log.Errorf("Error while publishing scheduled message %s: %s", scheduled.ID, err)
+		errUpdate := Store().clScheduledMessages.Update(
+			bson.M{"_id": scheduled.ID},
+			bson.M{"$set": bson.M{"status": ScheduledError, "error": err.Error()}})
+		if errUpdate != nil {
+			log.Errorf("Error while setting error on scheduled message %s: %s", scheduled.ID, errUpdate)
+		}
+		return
+	}
+
+	// published status is never claimed again, if removal fails
+	_, err = Store().clScheduledMessages.Find(bson.M{"_id": scheduled.ID, "idMessage": scheduled.IDMessage}).
+		Apply(mgo.Change{Update: bson.M{"$set": bson.M{"status": ScheduledPublished}}}, &ScheduledMessage{})
+	if err != nil {
+		log.Errorf("Error while setting published scheduled message %s: %s", scheduled.ID, err)
+	}
+	if err := Store().clScheduledMessages.Remove(bson.M{"_id": scheduled.ID}); err != nil {
+		log.Errorf("Error while removing published scheduled message %s: %s", scheduled.ID, err)
+	}
+	go func() {
+		for _, t := range message.Topics {
+			WSMessageNew(&WSMessageNewJSON{Topic: t})
+		}
+		WSMessage(&WSMessageJSON{Action: "create", Username: user.Username, Message: message})
+	}()
+}
+
+func changeAuthorUsernameOnScheduledMessages(oldUsername, newUsername string) error {
+	_, err := Store().clScheduledMessages.UpdateAll(
+		bson.M{"author.username": oldUsername},
+		bson.M{"$set": bson.M{"author.username": newUsername}})
+
+	if err != nil {
+		log.Errorf("Error while update username from %s to %s on Scheduled Messages %s", oldUsername, newUsername, err)
+	}
+
+	return err
+}
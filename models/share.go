@@ -0,0 +1,80 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ovh/tat/utils"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// a Tasks topic is updated by task and untask actions, not by share
+var tasksTopicRegexp = regexp.MustCompile(`^/Private/[^/]+/Tasks$`)
+
+// threadSelector returns selector of all messages of thread of message
+func (message *Message) threadSelector() bson.M {
+	idRoot := message.ID
+	if message.InReplyOfIDRoot != "" {
+		idRoot = message.InReplyOfIDRoot
+	}
+	return bson.M{"$or": []bson.M{bson.M{"_id": idRoot}, bson.M{"inReplyOfIDRoot": idRoot}}}
+}
+
+// Share adds topic to topics of all messages of thread of message.
+// First topic of a message stays its original topic
+func (message *Message) Share(topic Topic) error {
+	if tasksTopicRegexp.MatchString(topic.Topic) {
+		return fmt.Errorf("Share not possible on a Tasks topic, use task action")
+	}
+	if utils.ArrayContains(message.Topics, topic.Topic) {
+		return fmt.Errorf("Message %s is already on topic %s", message.ID, topic.Topic)
+	}
+
+	_, err := Store().clMessages.UpdateAll(
+		message.threadSelector(),
+		bson.M{"$addToSet": bson.M{"topics": topic.Topic}})
+	if err != nil {
+		log.Errorf("Error while sharing thread of message %s on topic %s: %s", message.ID, topic.Topic, err)
+		return err
+	}
+	message.Topics = append(message.Topics, topic.Topic)
+	return nil
+}
+
+// Unshare removes topic from topics of all messages of thread of message,
+// messages of thread are unpinned from topic. Original topic can't be removed
+func (message *Message) Unshare(topic Topic) error {
+	if !utils.ArrayContains(message.Topics, topic.Topic) {
+		return fmt.Errorf("Message %s is not on topic %s", message.ID, topic.Topic)
+	}
+	if message.Topics[0] == topic.Topic {
+		return fmt.Errorf("Unshare not possible, %s is the original topic of message, use move or delete", topic.Topic)
+	}
+	if tasksTopicRegexp.MatchString(topic.Topic) {
+		return fmt.Errorf("Unshare not possible on a Tasks topic, use untask action")
+	}
+
+	if err := unshareMessages(message.threadSelector(), topic.Topic); err != nil {
+		log.Errorf("Error while unsharing thread of message %s from topic %s: %s", message.ID, topic.Topic, err)
+		return err
+	}
+
+	var topics []string
+	for _, t := range message.Topics {
+		if t != topic.Topic {
+			topics = append(topics, t)
+		}
+	}
+	message.Topics = topics
+	return nil
+}
+
+// unshareMessages removes topic from messages matching selector, and unpins them from topic
+func unshareMessages(selector bson.M, topic string) error {
+	if err := unpinMessages(selector, topic); err != nil {
+		return err
+	}
+	_, err := Store().clMessages.UpdateAll(selector, bson.M{"$pull": bson.M{"topics": topic}})
+	return err
+}
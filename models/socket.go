@@ -25,6 +25,8 @@ type WSPresenceJSON struct {
 type WSMessageJSON struct {
 	Action   string  `json:"action"`
 	Username string  `json:"username"`
+	Topic    string  `json:"topic,omitempty"`    // topic shared, unshared, or left by move
+	Reaction string  `json:"reaction,omitempty"` // reaction added or removed
 	Message  Message `json:"message"`
 }
 
@@ -34,6 +36,35 @@ type WSMessageNewJSON struct {
 	Topic string `json:"topic"`
 }
 
+// WSPinJSON is used by Tat websocket, on pin or unpin of a message
+// From Tat to client
+type WSPinJSON struct {
+	Action   string   `json:"action"`
+	Username string   `json:"username"`
+	Topic    string   `json:"topic"`
+	Pinned   []string `json:"pinned"`
+	Message  Message  `json:"message"`
+}
+
+// WSBoardJSON is used by Tat websocket, on update of a root message
+// of a board. Columns are labels of message which are columns of board
+// From Tat to client
+type WSBoardJSON struct {
+	Action   string   `json:"action"`
+	Username string   `json:"username"`
+	Topic    string   `json:"topic"`
+	Columns  []string `json:"columns"`
+	Message  Message  `json:"message"`
+}
+
+// WSNotificationsJSON is used by Tat websocket
+// From Tat to client, number of unread notifications of user
+type WSNotificationsJSON struct {
+	Username string         `json:"username"`
+	Count    int            `json:"count"`
+	ByTopic  map[string]int `json:"byTopic"`
+}
+
 // WSUserJSON is used by Tat websocket
 // From Tat to client
 type WSUserJSON struct {
@@ -47,6 +78,7 @@ type WSJSON struct {
 	Status   string   `json:"status"`
 	TreeView string   `json:"treeView"`
 	Topics   []string `json:"topics"`
+	Columns  []string `json:"columns"`
 }
 
 // WSConnectJSON represents a json from client to tat, connect action
@@ -73,6 +105,7 @@ var activeUsers = struct {
 type subscriptionVal struct {
 	instance string
 	treeView string
+	columns  []string
 }
 
 // key topic, list of subscriptionMsgVal
@@ -93,6 +126,12 @@ var subscriptionPresences = struct {
 	m map[string][]subscriptionVal
 }{m: make(map[string][]subscriptionVal)}
 
+// key topic, list of subscriptionVal with columns of board
+var subscriptionBoards = struct {
+	sync.RWMutex
+	m map[string][]subscriptionVal
+}{m: make(map[string][]subscriptionVal)}
+
 // key Socket.instance
 var subscriptionUsers = struct {
 	sync.RWMutex
@@ -120,6 +159,7 @@ func SocketsDump() gin.H {
 		"subscriptionMessages":    fmt.Sprintf("%+v", subscriptionMessages.m),
 		"subscriptionMessagesNew": fmt.Sprintf("%+v", subscriptionMessagesNew.m),
 		"subscriptionPresences":   fmt.Sprintf("%+v", subscriptionPresences.m),
+		"subscriptionBoards":      fmt.Sprintf("%+v", subscriptionBoards.m),
 		"subscriptionUsers":       fmt.Sprintf("%+v", subscriptionUsers.m),
 	}
 }
@@ -261,6 +301,10 @@ func (socket *Socket) work(msg WSJSON) {
 		socket.actionSubscribeMessagesNew(msg)
 	case "unsubscribeMessagesNew":
 		socket.actionUnsubscribeMessagesNew(msg)
+	case "subscribeBoard":
+		socket.actionSubscribeBoard(msg)
+	case "unsubscribeBoard":
+		socket.actionUnsubscribeBoard(msg)
 	case "subscribePresences":
 		socket.actionSubscribePresences(msg)
 	case "unsubscribePresences":
@@ -406,6 +450,44 @@ func (socket *Socket) actionUnsubscribeMessagesNew(msg WSJSON) {
 	}
 }
 
+func (socket *Socket) actionSubscribeBoard(msg WSJSON) {
+	if len(msg.Columns) == 0 || len(msg.Columns) > MaxBoardColumns {
+		m := fmt.Sprintf("Invalid number of columns (%d) for action %s, max is %d", len(msg.Columns), msg.Action, MaxBoardColumns)
+		socket.write(gin.H{"action": msg.Action, "result": m, "status": http.StatusBadRequest})
+		return
+	}
+	topics, _, err := socket.preCheckWSTopics(msg)
+	if err != nil {
+		return
+	}
+	sVal := subscriptionVal{
+		instance: socket.instance,
+		columns:  msg.Columns,
+	}
+	subscriptionBoards.Lock()
+	for _, topic := range topics {
+		if subscriptionArrContains(subscriptionBoards.m[topic.Topic], socket.instance) {
+			socket.write(gin.H{"action": msg.Action, "result": fmt.Sprintf("%s topic %s KO : already Subscribe", msg.Action, topic.Topic), "status": http.StatusConflict})
+		} else {
+			subscriptionBoards.m[topic.Topic] = append(subscriptionBoards.m[topic.Topic], sVal)
+			socket.write(gin.H{"action": msg.Action, "result": fmt.Sprintf("%s topic %s OK", msg.Action, topic.Topic), "status": http.StatusOK})
+		}
+	}
+	subscriptionBoards.Unlock()
+}
+
+func (socket *Socket) actionUnsubscribeBoard(msg WSJSON) {
+	topics, _, err := socket.preCheckWSTopics(msg)
+	if err != nil {
+		return
+	}
+
+	for _, topic := range topics {
+		socket.deleteUserFromBoard(topic.Topic)
+		socket.write(gin.H{"action": msg.Action, "result": fmt.Sprintf("%s topic %s OK", msg.Action, topic.Topic), "status": http.StatusOK})
+	}
+}
+
 func (socket *Socket) actionSubscribePresences(msg WSJSON) {
 	topics, _, err := socket.preCheckWSTopics(msg)
 	if err != nil {
@@ -498,6 +580,7 @@ func (socket *Socket) ping() error {
 func (socket *Socket) deleteUserFromAll() {
 	socket.deleteUserFromMessages()
 	socket.deleteUserFromPresences()
+	socket.deleteUserFromBoards()
 	subscriptionUsers.Lock()
 	delete(subscriptionUsers.m, socket.instance)
 	subscriptionUsers.Unlock()
@@ -530,6 +613,18 @@ func (socket *Socket) deleteUserFromMessage(topicName string) {
 	subscriptionMessages.Unlock()
 }
 
+func (socket *Socket) deleteUserFromBoards() {
+	subscriptionBoards.Lock()
+	socket.deleteUserFromAllList(subscriptionBoards.m)
+	subscriptionBoards.Unlock()
+}
+
+func (socket *Socket) deleteUserFromBoard(topicName string) {
+	subscriptionBoards.Lock()
+	socket.deleteUserFromList(topicName, subscriptionBoards.m)
+	subscriptionBoards.Unlock()
+}
+
 func (socket *Socket) deleteUserFromMessagesCount() {
 	subscriptionMessagesNew.Lock()
 	socket.deleteUserFromAllList(subscriptionMessagesNew.m)
@@ -574,70 +669,133 @@ func WSMessageNew(msg *WSMessageNewJSON) {
 	subscriptionMessagesNew.RUnlock()
 }
 
-// WSMessage writes event messages
+// treeEventKeys are keys of tree in event messages, by treeView
+var treeEventKeys = map[string]string{"onetree": "oneTree", "fulltree": "fullTree"}
+
+// wsTopics returns topics of message of event, with topic
+// of event, to write event to subscribers of all of them
+func wsTopics(msg *WSMessageJSON) []string {
+	topics := msg.Message.Topics
+	if msg.Topic != "" && !utils.ArrayContains(topics, msg.Topic) {
+		topics = append([]string{msg.Topic}, topics...)
+	}
+	return topics
+}
+
+// WSMessage writes event messages to subscribers of each topic of message,
+// once by subscriber, and triggers webhooks of topics of message
 func WSMessage(msg *WSMessageJSON) {
 	w := gin.H{"eventMsg": msg}
+	wsBoard(msg)
+	// webhooks are looked up and delivered asynchronously, out of request
+	go triggerHooks(msg)
 
-	var oneTree, fullTree, msgs []Message
-	var wOneTree, wFullTree = gin.H{}, gin.H{}
-	c := &MessageCriteria{}
-	c.AllIDMessage = msg.Message.InReplyOfIDRoot
-	isGetMsgs := false
+	// trees are loaded once, only if a subscriber needs them
+	trees := map[string]gin.H{}
+	sent := map[string]bool{}
 
 	subscriptionMessages.RLock()
-	for _, sVal := range subscriptionMessages.m[msg.Message.Topics[0]] {
-		activeUsers.RLock()
-
-		if msg.Message.InReplyOfIDRoot == "" || sVal.treeView == "" {
-			activeUsers.m[sVal.instance].write(w)
-			activeUsers.RUnlock()
-			continue
+	for _, topic := range wsTopics(msg) {
+		for _, sVal := range subscriptionMessages.m[topic] {
+			if !sent[sVal.instance] {
+				sent[sVal.instance] = true
+				wsMessageWrite(msg, sVal, w, trees)
+			}
 		}
+	}
+	subscriptionMessages.RUnlock()
+}
+
+// wsMessageWrite writes event message to a subscriber, with tree of
+// message if subscriber has a treeView
+func wsMessageWrite(msg *WSMessageJSON, sVal subscriptionVal, w gin.H, trees map[string]gin.H) {
+	activeUsers.RLock()
+	defer activeUsers.RUnlock()
 
-		// below is for treeView
+	if msg.Message.InReplyOfIDRoot == "" || sVal.treeView == "" {
+		activeUsers.m[sVal.instance].write(w)
+		return
+	}
 
-		// getting messages related to ID, only once
-		if !isGetMsgs {
-			msgs, _ = ListMessages(c)
-			isGetMsgs = true
+	treeKey, ok := treeEventKeys[sVal.treeView]
+	if !ok {
+		log.Warnf("Invalid souscription tree, send no tree")
+		activeUsers.m[sVal.instance].write(w)
+		return
+	}
+
+	wTree, ok := trees[sVal.treeView]
+	if !ok {
+		wTree = gin.H{"eventMsgNew": msg}
+		tree, err := loadThreads([]Message{msg.Message}, &MessageCriteria{TreeView: sVal.treeView})
+		if err == nil && len(tree) > 0 {
+			wTree[treeKey] = tree[0]
 		}
+		trees[sVal.treeView] = wTree
+	}
+	activeUsers.m[sVal.instance].write(wTree)
+}
 
-		switch sVal.treeView {
-		case "onetree":
-			// only once to call oneTreeMessages
-			if len(oneTree) == 0 {
-				oneTree, _ = oneTreeMessages(msgs, 1, c)
-				if len(oneTree) == 0 {
-					// fake init oneTree
-					oneTree = []Message{msg.Message}
-					wOneTree = gin.H{"eventMsgNew": msg}
-				} else {
-					wOneTree = gin.H{"eventMsgNew": msg, "oneTree": oneTree[0]}
-				}
-			}
-			activeUsers.m[sVal.instance].write(wOneTree)
-		case "fulltree":
-			// only once to call oneTreeMessages
-			if len(fullTree) == 0 {
-				fullTree, _ = fullTreeMessages(msgs, 1, c)
-				if len(fullTree) == 0 {
-					// fake init fullTree
-					fullTree = []Message{msg.Message}
-					wFullTree = gin.H{"eventMsgNew": msg}
-				} else {
-					wFullTree = gin.H{"eventMsgNew": msg, "fullTree": fullTree[0]}
-				}
-			}
-			activeUsers.m[sVal.instance].write(wFullTree)
-		default:
-			log.Warnf("Invalid souscription tree, send no tree")
+// wsBoard writes event board to users subscribed to board of a topic
+// of message, on update of a root message
+func wsBoard(msg *WSMessageJSON) {
+	if msg.Message.InReplyOfIDRoot != "" {
+		return
+	}
+	subscriptionBoards.RLock()
+	for _, topic := range wsTopics(msg) {
+		for _, sVal := range subscriptionBoards.m[topic] {
+			w := gin.H{"eventBoard": &WSBoardJSON{
+				Action:   msg.Action,
+				Username: msg.Username,
+				Topic:    topic,
+				Columns:  boardColumns(msg.Message, sVal.columns),
+				Message:  msg.Message,
+			}}
+			activeUsers.RLock()
 			activeUsers.m[sVal.instance].write(w)
+			activeUsers.RUnlock()
 		}
+	}
+	subscriptionBoards.RUnlock()
+}
+
+// WSPin writes event pin to users subscribed to messages of topic
+func WSPin(p *WSPinJSON) {
+	w := gin.H{"eventPin": p}
+	subscriptionMessages.RLock()
+	for _, sVal := range subscriptionMessages.m[p.Topic] {
+		activeUsers.RLock()
+		activeUsers.m[sVal.instance].write(w)
 		activeUsers.RUnlock()
 	}
 	subscriptionMessages.RUnlock()
 }
 
+// isActiveUser returns true if user has at least one websocket connected
+func isActiveUser(username string) bool {
+	activeUsers.RLock()
+	defer activeUsers.RUnlock()
+	for _, socket := range activeUsers.m {
+		if socket.username == username {
+			return true
+		}
+	}
+	return false
+}
+
+// WSNotifications writes event notifications to all connected clients of user
+func WSNotifications(n *WSNotificationsJSON) {
+	w := gin.H{"eventNotifications": n}
+	activeUsers.RLock()
+	for _, socket := range activeUsers.m {
+		if socket.username == n.Username {
+			socket.write(w)
+		}
+	}
+	activeUsers.RUnlock()
+}
+
 // WSPresence writes event presences
 func WSPresence(p *WSPresenceJSON) {
 	w := gin.H{"eventPresence": p}
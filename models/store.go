@@ -4,6 +4,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -12,24 +13,38 @@ import (
 )
 
 const (
-	databaseName        = "tat"
-	collectionGroups    = "groups"
-	collectionMessages  = "messages"
-	collectionPresences = "presences"
-	collectionTopics    = "topics"
-	collectionUsers     = "users"
-	collectionSockets   = "sockets"
+	databaseName                = "tat"
+	collectionGroups            = "groups"
+	collectionMessages          = "messages"
+	collectionPresences         = "presences"
+	collectionTopics            = "topics"
+	collectionUsers             = "users"
+	collectionSockets           = "sockets"
+	collectionRevisions         = "revisions"
+	collectionScheduledMessages = "scheduled_messages"
+	collectionHooks             = "hooks"
+	collectionHookDeliveries    = "hook_deliveries"
+	collectionIncomingHooks     = "incoming_hooks"
+	collectionRateLimits        = "ratelimits"
+	collectionMigrations        = "migrations"
 )
 
 // MongoStore stores MongoDB Session and collections
 type MongoStore struct {
-	session     *mgo.Session
-	clGroups    *mgo.Collection
-	clMessages  *mgo.Collection
-	clPresences *mgo.Collection
-	clTopics    *mgo.Collection
-	clUsers     *mgo.Collection
-	clSockets   *mgo.Collection
+	session             *mgo.Session
+	clGroups            *mgo.Collection
+	clMessages          *mgo.Collection
+	clPresences         *mgo.Collection
+	clTopics            *mgo.Collection
+	clUsers             *mgo.Collection
+	clSockets           *mgo.Collection
+	clRevisions         *mgo.Collection
+	clScheduledMessages *mgo.Collection
+	clHooks             *mgo.Collection
+	clHookDeliveries    *mgo.Collection
+	clIncomingHooks     *mgo.Collection
+	clRateLimits        *mgo.Collection
+	clMigrations        *mgo.Collection
 }
 
 var _initCtx sync.Once
@@ -90,17 +105,102 @@ func NewStore() {
 	}
 
 	_instance = &MongoStore{
-		session:     session,
-		clGroups:    session.DB(databaseName).C(collectionGroups),
-		clMessages:  session.DB(databaseName).C(collectionMessages),
-		clPresences: session.DB(databaseName).C(collectionPresences),
-		clTopics:    session.DB(databaseName).C(collectionTopics),
-		clUsers:     session.DB(databaseName).C(collectionUsers),
-		clSockets:   session.DB(databaseName).C(collectionSockets),
+		session:             session,
+		clGroups:            session.DB(databaseName).C(collectionGroups),
+		clMessages:          session.DB(databaseName).C(collectionMessages),
+		clPresences:         session.DB(databaseName).C(collectionPresences),
+		clTopics:            session.DB(databaseName).C(collectionTopics),
+		clUsers:             session.DB(databaseName).C(collectionUsers),
+		clSockets:           session.DB(databaseName).C(collectionSockets),
+		clRevisions:         session.DB(databaseName).C(collectionRevisions),
+		clScheduledMessages: session.DB(databaseName).C(collectionScheduledMessages),
+		clHooks:             session.DB(databaseName).C(collectionHooks),
+		clHookDeliveries:    session.DB(databaseName).C(collectionHookDeliveries),
+		clIncomingHooks:     session.DB(databaseName).C(collectionIncomingHooks),
+		clRateLimits:        session.DB(databaseName).C(collectionRateLimits),
+		clMigrations:        session.DB(databaseName).C(collectionMigrations),
 	}
 
 	initDb()
 	ensureIndexes(_instance)
+	runMigration("likesToReactions", migrateLikesToReactions)
+}
+
+// Status of migrations
+const (
+	MigrationRunning = "running"
+	MigrationDone    = "done"
+)
+
+// migrationTimeout is the time in seconds after which a running migration is
+// considered stale, its instance of Tat stopped, and can be claimed again
+const migrationTimeout = 3600
+
+// migrationWait is the time in seconds between two checks of a migration
+// run by another instance of Tat
+const migrationWait = 10
+
+// Migration struct, a migration of data run once, by one instance of Tat
+type Migration struct {
+	ID        string `bson:"_id"`
+	Status    string `bson:"status"`
+	DateStart int64  `bson:"dateStart"`
+	DateEnd   int64  `bson:"dateEnd,omitempty"`
+}
+
+// runMigration runs migrate once, before routes of Tat are served: migration is
+// recorded in database before its start, and is removed if migrate fails, to be
+// run on next start. A migration running on another instance of Tat is waited for
+func runMigration(name string, migrate func() error) {
+	for {
+		claimed, err := claimMigration(name)
+		if err != nil {
+			log.Errorf("Error while starting migration %s: %s", name, err)
+			return
+		}
+		if claimed {
+			break
+		}
+		var migration Migration
+		if err := Store().clMigrations.FindId(name).One(&migration); err == nil && migration.Status == MigrationDone {
+			return
+		}
+		log.Infof("Waiting for migration %s run by another instance of Tat", name)
+		time.Sleep(migrationWait * time.Second)
+	}
+
+	if err := migrate(); err != nil {
+		log.Errorf("Error while running migration %s, it will be run on next start: %s", name, err)
+		Store().clMigrations.RemoveId(name)
+		return
+	}
+	err := Store().clMigrations.UpdateId(name, bson.M{"$set": bson.M{"status": MigrationDone, "dateEnd": time.Now().Unix()}})
+	if err != nil {
+		log.Errorf("Error while ending migration %s: %s", name, err)
+	}
+}
+
+// claimMigration records migration as running, returns false if migration
+// is done, or running on another instance of Tat since less than migrationTimeout
+func claimMigration(name string) (bool, error) {
+	now := time.Now().Unix()
+	err := Store().clMigrations.Insert(&Migration{ID: name, Status: MigrationRunning, DateStart: now})
+	if err == nil {
+		return true, nil
+	} else if !mgo.IsDup(err) {
+		return false, err
+	}
+
+	err = Store().clMigrations.Update(
+		bson.M{"_id": name, "status": MigrationRunning, "dateStart": bson.M{"$lt": now - migrationTimeout}},
+		bson.M{"$set": bson.M{"dateStart": now}})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	log.Warnf("Migration %s was stale, it is run again", name)
+	return true, nil
 }
 
 // getDbParameter gets value of tat parameter
@@ -135,18 +235,40 @@ func ensureIndexes(store *MongoStore) {
 	listIndex(store.clGroups, false)
 	listIndex(store.clUsers, false)
 	listIndex(store.clPresences, false)
+	listIndex(store.clRevisions, false)
+	listIndex(store.clScheduledMessages, false)
+	listIndex(store.clHooks, false)
+	listIndex(store.clHookDeliveries, false)
+	listIndex(store.clIncomingHooks, false)
+	listIndex(store.clRateLimits, false)
 
 	ensureIndex(store.clMessages, mgo.Index{Key: []string{"topics", "-dateUpdate", "-dateCreation"}})
 	ensureIndex(store.clMessages, mgo.Index{Key: []string{"topics", "-dateCreation"}})
+	ensureIndex(store.clMessages, mgo.Index{Key: []string{"topics", "-dateCreation", "-_id"}})
 	ensureIndex(store.clMessages, mgo.Index{Key: []string{"tags"}})
 	ensureIndex(store.clMessages, mgo.Index{Key: []string{"labels.text"}})
+	ensureIndex(store.clMessages, mgo.Index{Key: []string{"reactions.text"}})
+	ensureIndex(store.clMessages, mgo.Index{Key: []string{"attributes.key", "attributes.value"}})
 	ensureIndex(store.clMessages, mgo.Index{Key: []string{"inReplyOfID"}})
 	ensureIndex(store.clMessages, mgo.Index{Key: []string{"inReplyOfIDRoot"}})
+	ensureIndex(store.clMessages, mgo.Index{Key: []string{"$text:text"}})
+	ensureExternalKeyIndex(store)
 	ensureIndex(store.clTopics, mgo.Index{Key: []string{"topic"}, Unique: true})
 	ensureIndex(store.clGroups, mgo.Index{Key: []string{"name"}, Unique: true})
 	ensureIndex(store.clUsers, mgo.Index{Key: []string{"username"}, Unique: true})
 	ensureIndex(store.clUsers, mgo.Index{Key: []string{"email"}, Unique: true})
+	ensureIndex(store.clUsers, mgo.Index{Key: []string{"emailNotifications.mode", "emailNotifications.dateLastSent"}})
 	ensureIndex(store.clPresences, mgo.Index{Key: []string{"topic", "-dateTimePresence"}})
+	ensureIndex(store.clRevisions, mgo.Index{Key: []string{"idMessage", "revision"}, Unique: true})
+	ensureIndex(store.clScheduledMessages, mgo.Index{Key: []string{"status", "datePublish"}})
+	ensureIndex(store.clScheduledMessages, mgo.Index{Key: []string{"author.username", "datePublish"}})
+	ensureIndex(store.clHooks, mgo.Index{Key: []string{"topic", "events"}})
+	ensureIndex(store.clHookDeliveries, mgo.Index{Key: []string{"idHook", "-dateCreation"}})
+	ensureIndex(store.clHookDeliveries, mgo.Index{Key: []string{"date"}, ExpireAfter: HookDeliveriesMaxAge})
+	ensureIndex(store.clHookDeliveries, mgo.Index{Key: []string{"status", "nextAttempt"}})
+	ensureIndex(store.clIncomingHooks, mgo.Index{Key: []string{"topic"}})
+	ensureIndex(store.clRateLimits, mgo.Index{Key: []string{"dateLast"}, ExpireAfter: RateLimitBucketsMaxAge})
+	ensureIndex(store.clRateLimits, mgo.Index{Key: []string{"-nbRejected"}})
 }
 
 func listIndex(col *mgo.Collection, drop bool) {
@@ -165,6 +287,43 @@ func listIndex(col *mgo.Collection, drop bool) {
 	}
 }
 
+// externalKeyIndexName is name of unique index on external keys of messages, by topic
+const externalKeyIndexName = "topics_1_externalKey_1_unique"
+
+// ensureExternalKeyIndex creates unique index on external key of messages, by topic.
+// Index is partial, on messages with an external key only: it is not handled by
+// mgo.Index. Previous index, not unique, is dropped
+func ensureExternalKeyIndex(store *MongoStore) {
+	indexes, err := store.clMessages.Indexes()
+	if err != nil {
+		log.Fatalf("Error while getting indexes on %s: %s", store.clMessages.Name, err)
+		return
+	}
+	for _, index := range indexes {
+		if index.Name == externalKeyIndexName {
+			return
+		}
+		if index.Name == "topics_1_externalKey_1" {
+			if err := store.clMessages.DropIndexName(index.Name); err != nil {
+				log.Warnf("Error while dropping index %s: %s", index.Name, err)
+			}
+		}
+	}
+
+	err = store.clMessages.Database.Run(bson.D{
+		{Name: "createIndexes", Value: store.clMessages.Name},
+		{Name: "indexes", Value: []bson.M{{
+			"key":                     bson.D{{Name: "topics", Value: 1}, {Name: "externalKey", Value: 1}},
+			"name":                    externalKeyIndexName,
+			"unique":                  true,
+			"partialFilterExpression": bson.M{"externalKey": bson.M{"$exists": true}},
+		}}},
+	}, nil)
+	if err != nil {
+		log.Fatalf("Error while creating unique index on external keys of messages, check duplicate external keys on topics: %s", err)
+	}
+}
+
 func ensureIndex(col *mgo.Collection, index mgo.Index) {
 	err := col.EnsureIndex(index)
 	if err != nil {
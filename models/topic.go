@@ -14,26 +14,35 @@ import (
 
 // Topic struct
 type Topic struct {
-	ID               string           `bson:"_id"          json:"_id,omitempty"`
-	Topic            string           `bson:"topic"        json:"topic"`
-	Description      string           `bson:"description"  json:"description"`
-	ROGroups         []string         `bson:"roGroups"     json:"roGroups,omitempty"`
-	RWGroups         []string         `bson:"rwGroups"     json:"rwGroups,omitempty"`
-	ROUsers          []string         `bson:"roUsers"      json:"roUsers,omitempty"`
-	RWUsers          []string         `bson:"rwUsers"      json:"rwUsers,omitempty"`
-	AdminUsers       []string         `bson:"adminUsers"   json:"adminUsers,omitempty"`
-	AdminGroups      []string         `bson:"adminGroups"  json:"adminGroups,omitempty"`
-	History          []string         `bson:"history"      json:"history"`
-	MaxLength        int              `bson:"maxlength"    json:"maxlength"`
-	CanForceDate     bool             `bson:"canForceDate" json:"canForceDate"`
-	CanUpdateMsg     bool             `bson:"canUpdateMsg" json:"canUpdateMsg"`
-	CanDeleteMsg     bool             `bson:"canDeleteMsg" json:"canDeleteMsg"`
-	CanUpdateAllMsg  bool             `bson:"canUpdateAllMsg" json:"canUpdateAllMsg"`
-	CanDeleteAllMsg  bool             `bson:"canDeleteAllMsg" json:"canDeleteAllMsg"`
-	IsROPublic       bool             `bson:"isROPublic"   json:"isROPublic"`
-	DateModification int64            `bson:"dateModification" json:"dateModificationn,omitempty"`
-	DateCreation     int64            `bson:"dateCreation" json:"dateCreation,omitempty"`
-	Parameters       []TopicParameter `bson:"parameters" json:"parameters,omitempty"`
+	ID                string           `bson:"_id"          json:"_id,omitempty"`
+	Topic             string           `bson:"topic"        json:"topic"`
+	Description       string           `bson:"description"  json:"description"`
+	ROGroups          []string         `bson:"roGroups"     json:"roGroups,omitempty"`
+	RWGroups          []string         `bson:"rwGroups"     json:"rwGroups,omitempty"`
+	ROUsers           []string         `bson:"roUsers"      json:"roUsers,omitempty"`
+	RWUsers           []string         `bson:"rwUsers"      json:"rwUsers,omitempty"`
+	AdminUsers        []string         `bson:"adminUsers"   json:"adminUsers,omitempty"`
+	AdminGroups       []string         `bson:"adminGroups"  json:"adminGroups,omitempty"`
+	History           []string         `bson:"history"      json:"history"`
+	MaxLength         int              `bson:"maxlength"    json:"maxlength"`
+	CanForceDate      bool             `bson:"canForceDate" json:"canForceDate"`
+	CanUpdateMsg      bool             `bson:"canUpdateMsg" json:"canUpdateMsg"`
+	CanDeleteMsg      bool             `bson:"canDeleteMsg" json:"canDeleteMsg"`
+	CanUpdateAllMsg   bool             `bson:"canUpdateAllMsg" json:"canUpdateAllMsg"`
+	CanDeleteAllMsg   bool             `bson:"canDeleteAllMsg" json:"canDeleteAllMsg"`
+	IsROPublic        bool             `bson:"isROPublic"   json:"isROPublic"`
+	DateModification  int64            `bson:"dateModification" json:"dateModificationn,omitempty"`
+	DateCreation      int64            `bson:"dateCreation" json:"dateCreation,omitempty"`
+	Parameters        []TopicParameter `bson:"parameters" json:"parameters,omitempty"`
+	RetentionMaxAge   int              `bson:"retentionMaxAge"   json:"retentionMaxAge,omitempty"`
+	RetentionMaxCount int              `bson:"retentionMaxCount" json:"retentionMaxCount,omitempty"`
+	DateLastPurge     int64            `bson:"dateLastPurge"     json:"dateLastPurge,omitempty"`
+	Pinned            []string         `bson:"pinned"            json:"pinned,omitempty"`
+	LabelsCatalog     []TopicLabel     `bson:"labelsCatalog"     json:"labelsCatalog,omitempty"`
+	StrictLabels      bool             `bson:"strictLabels"      json:"strictLabels"`
+	Rules             []TopicRule      `bson:"rules"             json:"rules,omitempty"`
+	Validation        *TopicValidation `bson:"validation,omitempty" json:"validation,omitempty"`
+	RateLimit         *TopicRateLimit  `bson:"rateLimit,omitempty"  json:"rateLimit,omitempty"`
 }
 
 // TopicParameter struct, parameter on topics
@@ -158,15 +167,23 @@ func buildTopicCriteria(criteria *TopicCriteria, user *User) bson.M {
 func getTopicSelectedFields(isAdmin bool) bson.M {
 	if !isAdmin {
 		return bson.M{
-			"topic":           1,
-			"description":     1,
-			"isROPublic":      1,
-			"canUpdateMsg":    1,
-			"canDeleteMsg":    1,
-			"canUpdateAllMsg": 1,
-			"canDeleteAllMsg": 1,
-			"maxlength":       1,
-			"parameters":      1,
+			"topic":             1,
+			"description":       1,
+			"isROPublic":        1,
+			"canUpdateMsg":      1,
+			"canDeleteMsg":      1,
+			"canUpdateAllMsg":   1,
+			"canDeleteAllMsg":   1,
+			"maxlength":         1,
+			"parameters":        1,
+			"retentionMaxAge":   1,
+			"retentionMaxCount": 1,
+			"pinned":            1,
+			"labelsCatalog":     1,
+			"strictLabels":      1,
+			"rules":             1,
+			"validation":        1,
+			"rateLimit":         1,
 		}
 	}
 	return bson.M{}
@@ -341,6 +358,10 @@ func (topic *Topic) Insert(user *User) error {
 		topic.CanDeleteAllMsg = parentTopic.CanDeleteAllMsg
 		topic.IsROPublic = parentTopic.IsROPublic
 		topic.Parameters = parentTopic.Parameters
+		topic.RetentionMaxAge = parentTopic.RetentionMaxAge
+		topic.RetentionMaxCount = parentTopic.RetentionMaxCount
+		topic.LabelsCatalog = parentTopic.LabelsCatalog
+		topic.StrictLabels = parentTopic.StrictLabels
 	}
 
 	err = Store().clTopics.Insert(topic)
@@ -380,7 +401,12 @@ func (topic *Topic) Delete(user *User) error {
 		return fmt.Errorf("Could not delete this topic, this topic have messages")
 	}
 
-	return Store().clTopics.Remove(bson.M{"_id": topic.ID})
+	if err := Store().clTopics.Remove(bson.M{"_id": topic.ID}); err != nil {
+		return err
+	}
+	removeHooksOfTopic(topic.Topic)
+	removeIncomingHooksOfTopic(topic.Topic)
+	return nil
 }
 
 // Get parent topic
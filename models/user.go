@@ -31,21 +31,23 @@ type Auth struct {
 
 // User struct
 type User struct {
-	ID                     string    `bson:"_id"               json:"_id"`
-	Username               string    `bson:"username"          json:"username"`
-	Fullname               string    `bson:"fullname"          json:"fullname"`
-	Email                  string    `bson:"email"             json:"email,omitempty"`
-	Groups                 []string  `bson:"-"                 json:"groups,omitempty"`
-	IsAdmin                bool      `bson:"isAdmin"           json:"isAdmin,omitempty"`
-	IsSystem               bool      `bson:"isSystem"          json:"isSystem,omitempty"`
-	IsArchived             bool      `bson:"isArchived"        json:"isArchived,omitempty"`
-	CanWriteNotifications  bool      `bson:"canWriteNotifications" json:"canWriteNotifications,omitempty"`
-	FavoritesTopics        []string  `bson:"favoritesTopics"   json:"favoritesTopics,omitempty"`
-	OffNotificationsTopics []string  `bson:"offNotificationsTopics"   json:"offNotificationsTopics,omitempty"`
-	FavoritesTags          []string  `bson:"favoritesTags"     json:"favoritesTags,omitempty"`
-	DateCreation           int64     `bson:"dateCreation"      json:"dateCreation,omitempty"`
-	Contacts               []Contact `bson:"contacts"          json:"contacts,omitempty"`
-	Auth                   Auth      `bson:"auth" json:"-"`
+	ID                     string              `bson:"_id"               json:"_id"`
+	Username               string              `bson:"username"          json:"username"`
+	Fullname               string              `bson:"fullname"          json:"fullname"`
+	Email                  string              `bson:"email"             json:"email,omitempty"`
+	Groups                 []string            `bson:"-"                 json:"groups,omitempty"`
+	IsAdmin                bool                `bson:"isAdmin"           json:"isAdmin,omitempty"`
+	IsSystem               bool                `bson:"isSystem"          json:"isSystem,omitempty"`
+	IsArchived             bool                `bson:"isArchived"        json:"isArchived,omitempty"`
+	CanWriteNotifications  bool                `bson:"canWriteNotifications" json:"canWriteNotifications,omitempty"`
+	FavoritesTopics        []string            `bson:"favoritesTopics"   json:"favoritesTopics,omitempty"`
+	OffNotificationsTopics []string            `bson:"offNotificationsTopics"   json:"offNotificationsTopics,omitempty"`
+	EmailNotifications     *EmailNotifications `bson:"emailNotifications,omitempty" json:"emailNotifications,omitempty"`
+	NotificationsRead      *NotificationsRead  `bson:"notificationsRead,omitempty" json:"-"`
+	FavoritesTags          []string            `bson:"favoritesTags"     json:"favoritesTags,omitempty"`
+	DateCreation           int64               `bson:"dateCreation"      json:"dateCreation,omitempty"`
+	Contacts               []Contact           `bson:"contacts"          json:"contacts,omitempty"`
+	Auth                   Auth                `bson:"auth" json:"-"`
 }
 
 // UserCriteria is used to list users with criterias
@@ -302,6 +304,8 @@ func (user *User) getFieldsExceptAuth() bson.M {
 		"dateCreation":           1,
 		"favoritesTopics":        1,
 		"offNotificationsTopics": 1,
+		"emailNotifications":     1,
+		"notificationsRead":      1,
 		"favoritesTags":          1,
 		"contacts":               1,
 	}
@@ -0,0 +1,150 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ovh/tat/utils"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Rules of validation, returned in ValidationError
+const (
+	ValidationRuleText         = "text"
+	ValidationRuleMaxLength    = "maxLength"
+	ValidationRuleMinLength    = "minLength"
+	ValidationRuleTextRegex    = "textRegex"
+	ValidationRuleRequiredTags = "requiredTags"
+	ValidationRuleAllowedTags  = "allowedTags"
+)
+
+// TopicValidation struct, validation of messages of a topic. A message contains at least
+// one tag of RequiredTags, only tags of AllowedTags, matches regex TextRegex and has
+// at least MinLength characters. Validation is checked on new threads and on their
+// update, and on replies if IncludeReplies. A text longer than maxlength of topic
+// is truncated, or rejected if RejectTooLong
+type TopicValidation struct {
+	RequiredTags   []string `bson:"requiredTags"   json:"requiredTags,omitempty"`
+	AllowedTags    []string `bson:"allowedTags"    json:"allowedTags,omitempty"`
+	TextRegex      string   `bson:"textRegex"      json:"textRegex,omitempty"`
+	MinLength      int      `bson:"minLength"      json:"minLength,omitempty"`
+	RejectTooLong  bool     `bson:"rejectTooLong"  json:"rejectTooLong,omitempty"`
+	IncludeReplies bool     `bson:"includeReplies" json:"includeReplies,omitempty"`
+}
+
+// ValidationError is returned on insert or update of a message
+// not valid on its topic, Rule is the rule failed
+type ValidationError struct {
+	Topic  string
+	Rule   string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("Invalid message on topic %s, rule %s: %s", e.Topic, e.Rule, e.Reason)
+}
+
+// isEmpty returns true if validation checks nothing
+func (v *TopicValidation) isEmpty() bool {
+	return len(v.RequiredTags) == 0 && len(v.AllowedTags) == 0 && v.TextRegex == "" && v.MinLength == 0 && !v.RejectTooLong
+}
+
+// SetValidation replaces validation of messages of topic, a nil or empty validation removes it
+func (topic *Topic) SetValidation(username string, validation *TopicValidation) error {
+	update := bson.M{"$unset": bson.M{"validation": ""}}
+	h := "remove validation"
+
+	if validation != nil && !validation.isEmpty() {
+		if _, err := regexp.Compile(validation.TextRegex); err != nil {
+			return fmt.Errorf("Invalid textRegex %s: %s", validation.TextRegex, err)
+		}
+		if validation.MinLength < 0 {
+			return fmt.Errorf("Invalid minLength %d", validation.MinLength)
+		}
+		if topic.MaxLength > 0 && validation.MinLength > topic.MaxLength {
+			return fmt.Errorf("Invalid minLength %d, greater than maxlength %d of topic", validation.MinLength, topic.MaxLength)
+		}
+		var err error
+		if validation.RequiredTags, err = cleanValidationTags(validation.RequiredTags); err != nil {
+			return err
+		}
+		if validation.AllowedTags, err = cleanValidationTags(validation.AllowedTags); err != nil {
+			return err
+		}
+		for _, tag := range validation.RequiredTags {
+			if len(validation.AllowedTags) > 0 && !utils.ArrayContains(validation.AllowedTags, tag) {
+				return fmt.Errorf("Invalid requiredTags, tag %s is not in allowedTags", tag)
+			}
+		}
+		update = bson.M{"$set": bson.M{"validation": validation}}
+		h = fmt.Sprintf("update validation to requiredTags:%s, allowedTags:%s, textRegex:%s, minLength:%d, rejectTooLong:%t, includeReplies:%t",
+			strings.Join(validation.RequiredTags, ","), strings.Join(validation.AllowedTags, ","),
+			validation.TextRegex, validation.MinLength, validation.RejectTooLong, validation.IncludeReplies)
+	} else {
+		validation = nil
+	}
+
+	if err := Store().clTopics.Update(bson.M{"_id": topic.ID}, update); err != nil {
+		log.Errorf("Error while updating validation of topic %s: %s", topic.Topic, err)
+		return err
+	}
+	topic.Validation = validation
+	return topic.addToHistory(bson.M{"_id": topic.ID}, username, h)
+}
+
+// cleanValidationTags removes # before tags, and checks there is no empty tag
+func cleanValidationTags(tags []string) ([]string, error) {
+	var cleaned []string
+	for _, tag := range tags {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "#")
+		if tag == "" {
+			return nil, fmt.Errorf("Invalid empty tag in validation")
+		}
+		if !utils.ArrayContains(cleaned, tag) {
+			cleaned = append(cleaned, tag)
+		}
+	}
+	return cleaned, nil
+}
+
+// checkValidation checks text and tags of message with validation of topic
+func (message *Message) checkValidation(topic Topic, tags []string) error {
+	v := topic.Validation
+	if v == nil || (message.InReplyOfID != "" && !v.IncludeReplies) {
+		return nil
+	}
+
+	if v.MinLength > 0 && utf8.RuneCountInString(message.Text) < v.MinLength {
+		return &ValidationError{Topic: topic.Topic, Rule: ValidationRuleMinLength,
+			Reason: fmt.Sprintf("text has %d characters, %d min", utf8.RuneCountInString(message.Text), v.MinLength)}
+	}
+	if v.TextRegex != "" && !regexpMatch(v.TextRegex, message.Text) {
+		return &ValidationError{Topic: topic.Topic, Rule: ValidationRuleTextRegex,
+			Reason: fmt.Sprintf("text does not match %s", v.TextRegex)}
+	}
+	if len(v.RequiredTags) > 0 {
+		found := false
+		for _, tag := range tags {
+			if utils.ArrayContains(v.RequiredTags, tag) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return &ValidationError{Topic: topic.Topic, Rule: ValidationRuleRequiredTags,
+				Reason: fmt.Sprintf("one of tags #%s is expected", strings.Join(v.RequiredTags, ", #"))}
+		}
+	}
+	if len(v.AllowedTags) > 0 {
+		for _, tag := range tags {
+			if !utils.ArrayContains(v.AllowedTags, tag) {
+				return &ValidationError{Topic: topic.Topic, Rule: ValidationRuleAllowedTags,
+					Reason: fmt.Sprintf("tag #%s is not allowed, allowed tags are #%s", tag, strings.Join(v.AllowedTags, ", #"))}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ovh/tat/controllers"
+)
+
+// InitRoutesHooks initialized routes for Hooks Controller
+func InitRoutesHooks(router *gin.Engine) {
+	hooksCtrl := &controllers.HooksController{}
+
+	g := router.Group("/hooks")
+	g.Use(CheckPassword())
+	{
+		// Outgoing webhooks of topics, admin of topic only
+		g.GET("", hooksCtrl.List)
+		g.POST("", hooksCtrl.Create)
+		g.PUT("/:idHook", hooksCtrl.Update)
+		g.DELETE("/:idHook", hooksCtrl.Delete)
+		g.GET("/:idHook/deliveries", hooksCtrl.Deliveries)
+	}
+}
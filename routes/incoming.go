@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ovh/tat/controllers"
+)
+
+// InitRoutesIncoming initialized routes for Incoming Controller
+func InitRoutesIncoming(router *gin.Engine) {
+	incomingCtrl := &controllers.IncomingController{}
+
+	g := router.Group("/incoming")
+	g.Use(CheckPassword())
+	{
+		// Incoming webhooks of topics, admin of topic only
+		g.GET("", incomingCtrl.List)
+		g.POST("", incomingCtrl.Create)
+		g.DELETE("/:idHook", incomingCtrl.Delete)
+	}
+
+	// Messages posted without credentials, token is checked by controller
+	router.POST("/incoming/:idHook/:token", incomingCtrl.Post)
+}
@@ -14,10 +14,20 @@ func InitRoutesMessages(router *gin.Engine) {
 	{
 		g.GET("/*topic", messagesCtrl.List)
 
+		// Create many messages, on many topics
+		g.POST("/bulk", messagesCtrl.CreateBulk)
+
 		// Delete a message and its replies
 		g.DELETE("/cascade/:idMessage", messagesCtrl.DeleteCascade)
 	}
 
+	gb := router.Group("/board")
+	gb.Use(CheckPassword())
+	{
+		// Root messages of a topic, by columns of labels
+		gb.GET("/*topic", messagesCtrl.Board)
+	}
+
 	r := router.Group("/read")
 	r.Use()
 	{
@@ -35,6 +45,10 @@ func InitRoutesMessages(router *gin.Engine) {
 
 		// Delete a message
 		gm.DELETE("/:idMessage", messagesCtrl.Delete)
+
+		// List previous texts of a message, diff two of them
+		gm.GET("/revisions/:idMessage", messagesCtrl.Revisions)
+		gm.GET("/revisions/:idMessage/diff", messagesCtrl.DiffRevisions)
 	}
 
 }
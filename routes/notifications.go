@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ovh/tat/controllers"
+)
+
+// InitRoutesNotifications initialized routes for Notifications Controller
+func InitRoutesNotifications(router *gin.Engine) {
+	notificationsCtrl := &controllers.NotificationsController{}
+
+	g := router.Group("/notifications")
+	g.Use(CheckPassword())
+	{
+		// Unread notifications of current user
+		g.GET("", notificationsCtrl.List)
+		g.GET("/count", notificationsCtrl.Count)
+
+		// Mark notifications as read
+		g.PUT("/read", notificationsCtrl.Read)
+	}
+}
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ovh/tat/controllers"
+)
+
+// InitRoutesScheduledMessages initialized routes for Scheduled Messages Controller
+func InitRoutesScheduledMessages(router *gin.Engine) {
+	scheduledCtrl := &controllers.ScheduledMessagesController{}
+
+	g := router.Group("/scheduled")
+	g.Use(CheckPassword())
+	{
+		// List scheduled messages of current user
+		g.GET("/messages", scheduledCtrl.List)
+
+		// Update or cancel a scheduled message
+		g.PUT("/message/:idScheduled", scheduledCtrl.Update)
+		g.DELETE("/message/:idScheduled", scheduledCtrl.Delete)
+	}
+}
@@ -22,5 +22,13 @@ func InitRoutesStats(router *gin.Engine) {
 		admin.GET("/db/collections", statsCtrl.DBStatsCollections)
 		admin.GET("/db/slowestQueries", statsCtrl.DBGetSlowestQueries)
 		admin.GET("/checkHeaders", statsCtrl.CheckHeaders)
+		admin.GET("/ratelimits", statsCtrl.RateLimits)
+	}
+
+	g := router.Group("/stats/messages")
+	g.Use(CheckPassword())
+	{
+		// Number of messages of a topic by period, read access on topic only
+		g.GET("/*topic", statsCtrl.Analytics)
 	}
 }
@@ -34,5 +34,18 @@ func InitRoutesTopics(router *gin.Engine) {
 		g.PUT("/topic/add/admingroup", topicsCtrl.AddAdminGroup)
 		g.PUT("/topic/remove/admingroup", topicsCtrl.RemoveAdminGroup)
 		g.PUT("/topic/param", topicsCtrl.SetParam)
+		g.PUT("/topic/retention", topicsCtrl.SetRetention)
+		g.PUT("/topic/labels", topicsCtrl.SetLabelsCatalog)
+		g.PUT("/topic/rules", topicsCtrl.SetRules)
+		g.POST("/topic/rules/dryrun", topicsCtrl.DryRunRules)
+		g.PUT("/topic/validation", topicsCtrl.SetValidation)
+		g.PUT("/topic/ratelimit", topicsCtrl.SetRateLimit)
+	}
+
+	admin := router.Group("/topics")
+	admin.Use(CheckPassword(), CheckAdmin())
+	{
+		admin.GET("/export/*topic", topicsCtrl.Export)
+		admin.POST("/import", topicsCtrl.Import)
 	}
 }
@@ -28,6 +28,7 @@ func InitRoutesUsers(router *gin.Engine) {
 
 		g.POST("/me/enable/notifications/topics/*topic", usersCtrl.EnableNotificationsTopic)
 		g.POST("/me/disable/notifications/topics/*topic", usersCtrl.DisableNotificationsTopic)
+		g.PUT("/me/notifications/email", usersCtrl.SetEmailNotifications)
 	}
 
 	admin := router.Group("/user")
@@ -43,6 +44,7 @@ func InitRoutesUsers(router *gin.Engine) {
 	}
 
 	router.GET("/user/verify/:username/:tokenVerify", usersCtrl.Verify)
+	router.GET("/user/unsubscribe/:username/:token", usersCtrl.UnsubscribeEmailNotifications)
 	router.POST("/user/reset", usersCtrl.Reset)
 	router.POST("/user", usersCtrl.Create)
 }
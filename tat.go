@@ -19,8 +19,7 @@ var mainCmd = &cobra.Command{
 	Short: "Run Tat Engine",
 	Long:  `Run Tat Engine`,
 	Run: func(cmd *cobra.Command, args []string) {
-		viper.SetEnvPrefix("tat")
-		viper.AutomaticEnv()
+		initConfig()
 
 		if viper.GetBool("production") {
 			// Only log the warning severity or above.
@@ -62,6 +61,16 @@ var mainCmd = &cobra.Command{
 		routes.InitRoutesStats(router)
 		routes.InitRoutesSystem(router)
 		routes.InitRoutesSockets(router)
+		routes.InitRoutesScheduledMessages(router)
+		routes.InitRoutesNotifications(router)
+		routes.InitRoutesHooks(router)
+		routes.InitRoutesIncoming(router)
+
+		go models.PublishScheduledMessages(viper.GetInt("scheduled_messages_period"))
+		go models.PurgeTopics(viper.GetInt("retention_purge_period"))
+		go models.SendEmailNotifications(viper.GetInt("notifications_email_period"))
+		go models.DeliverHooks(viper.GetInt("webhooks_period"))
+
 		router.Run(":" + viper.GetString("listen_port"))
 	},
 }
@@ -85,6 +94,20 @@ var versionCmd = &cobra.Command{
 func init() {
 	versionCmd.Flags().BoolVarP(&versionNewLine, "versionNewLine", "", true, "New line after version number")
 	mainCmd.AddCommand(versionCmd)
+	mainCmd.AddCommand(exportCmd)
+	mainCmd.AddCommand(importCmd)
+
+	// flags of mongodb are shared with export and import commands
+	pflags := mainCmd.PersistentFlags()
+	pflags.String("db-addr", "127.0.0.1:27017", "Address of the mongodb server")
+	pflags.String("db-user", "", "User to authenticate with the mongodb server. If \"false\", db-user is not used")
+	pflags.String("db-password", "", "Password to authenticate with the mongodb server. If \"false\", db-password is not used")
+	pflags.String("db-rs-tags", "", "Link hostname with tag on mongodb replica set - Optional: hostnameA:tagName:value,hostnameB:tagName:value. If \"false\", db-rs-tags is not used")
+
+	viper.BindPFlag("db_addr", pflags.Lookup("db-addr"))
+	viper.BindPFlag("db_user", pflags.Lookup("db-user"))
+	viper.BindPFlag("db_password", pflags.Lookup("db-password"))
+	viper.BindPFlag("db_rs_tags", pflags.Lookup("db-rs-tags"))
 
 	flags := mainCmd.Flags()
 
@@ -92,14 +115,14 @@ func init() {
 	flags.Bool("no-smtp", false, "No SMTP mode")
 	flags.String("tat-log-level", "", "Tat Log Level: debug, info or warn")
 	flags.String("listen-port", "8080", "Tat Engine Listen Port")
+	flags.Int("mentions-max-users", 100, "Max number of users notified by a message mentioning groups or topic-admins. 0: no limit")
+	flags.Int("notifications-email-period", 60, "Period in seconds between two sendings of emails of notifications. 0: emails of notifications are not sent by this instance")
+	flags.String("notifications-email-template", "", "File of template of an email of notifications, sent immediately. Empty: default template")
+	flags.String("notifications-digest-template", "", "File of template of an email of digest of notifications, sent hourly or daily. Empty: default template")
 	flags.String("exposed-scheme", "http", "Tat URI Scheme http or https exposed to client")
 	flags.String("exposed-host", "localhost", "Tat Engine Hostname exposed to client")
 	flags.String("exposed-port", "8080", "Tat Engine Port exposed to client")
 	flags.String("exposed-path", "", "Tat Engine Path exposed to client, ex: host:port/tat/engine /tat/engine is exposed path")
-	flags.String("db-addr", "127.0.0.1:27017", "Address of the mongodb server")
-	flags.String("db-user", "", "User to authenticate with the mongodb server. If \"false\", db-user is not used")
-	flags.String("db-password", "", "Password to authenticate with the mongodb server. If \"false\", db-password is not used")
-	flags.String("db-rs-tags", "", "Link hostname with tag on mongodb replica set - Optional: hostnameA:tagName:value,hostnameB:tagName:value. If \"false\", db-rs-tags is not used")
 	flags.String("smtp-host", "", "SMTP Host")
 	flags.String("smtp-port", "", "SMTP Port")
 	flags.Bool("smtp-tls", false, "SMTP TLS")
@@ -113,19 +136,30 @@ func init() {
 	flags.String("header-trust-username", "", "Header Trust Username: for example, if X-Remote-User and X-Remote-User received in header -> auto accept user without testing tat_password. Use it with precaution")
 	flags.String("trusted-usernames-emails-fullnames", "", "Tuples trusted username / email / fullname. Example: username:email:Firstname1_Fullname1,username2:email2:Firstname2_Fullname2")
 	flags.String("default-domain", "", "Default domains for mail for trusted username")
+	flags.Int("retention-purge-period", 3600, "Period in seconds between two purges of topics with a retention. 0: topics are not purged by this instance")
+	flags.Int("scheduled-messages-period", 10, "Period in seconds between two publications of scheduled messages. 0: scheduled messages are not published by this instance")
+	flags.String("webhooks-allowed-networks", "", "Networks allowed to webhooks, in loopback, private or link-local networks, denied by default. Ex: --webhooks-allowed-networks=10.0.0.0/8,192.168.1.0/24")
+	flags.Int("webhooks-period", 10, "Period in seconds between two retries of deliveries of webhooks in error. 0: deliveries are not retried by this instance")
+	flags.Int("webhooks-retries", 5, "Number of retries of a webhook delivery in error, with an exponential backoff from 10 seconds")
+	flags.Int("webhooks-timeout", 10, "Timeout in seconds of a webhook delivery")
+	flags.Int("rate-limit-user", 0, "Max number of messages created by minute by a user, on all topics. 0: no limit")
+	flags.Int("rate-limit-user-topic", 0, "Max number of messages created by minute by a user on a topic. 0: no limit")
+	flags.Int("rate-limit-system-user", 0, "Max number of messages created by minute by a system user, on all topics. 0: no limit")
+	flags.Int("rate-limit-system-user-topic", 0, "Max number of messages created by minute by a system user on a topic. 0: no limit")
+	flags.Int("rate-limit-topic", 0, "Max number of messages created by minute on a topic, by all users. 0: no limit")
 
 	viper.BindPFlag("production", flags.Lookup("production"))
 	viper.BindPFlag("no_smtp", flags.Lookup("no-smtp"))
 	viper.BindPFlag("tat_log_level", flags.Lookup("tat-log-level"))
 	viper.BindPFlag("listen_port", flags.Lookup("listen-port"))
+	viper.BindPFlag("mentions_max_users", flags.Lookup("mentions-max-users"))
+	viper.BindPFlag("notifications_email_period", flags.Lookup("notifications-email-period"))
+	viper.BindPFlag("notifications_email_template", flags.Lookup("notifications-email-template"))
+	viper.BindPFlag("notifications_digest_template", flags.Lookup("notifications-digest-template"))
 	viper.BindPFlag("exposed_scheme", flags.Lookup("exposed-scheme"))
 	viper.BindPFlag("exposed_host", flags.Lookup("exposed-host"))
 	viper.BindPFlag("exposed_port", flags.Lookup("exposed-port"))
 	viper.BindPFlag("exposed_path", flags.Lookup("exposed-path"))
-	viper.BindPFlag("db_addr", flags.Lookup("db-addr"))
-	viper.BindPFlag("db_user", flags.Lookup("db-user"))
-	viper.BindPFlag("db_password", flags.Lookup("db-password"))
-	viper.BindPFlag("db_rs_tags", flags.Lookup("db-rs-tags"))
 	viper.BindPFlag("smtp_host", flags.Lookup("smtp-host"))
 	viper.BindPFlag("smtp_port", flags.Lookup("smtp-port"))
 	viper.BindPFlag("smtp_tls", flags.Lookup("smtp-tls"))
@@ -139,6 +173,23 @@ func init() {
 	viper.BindPFlag("header_trust_username", flags.Lookup("header-trust-username"))
 	viper.BindPFlag("trusted_usernames_emails_fullnames", flags.Lookup("trusted-usernames-emails-fullnames"))
 	viper.BindPFlag("default_domain", flags.Lookup("default-domain"))
+	viper.BindPFlag("retention_purge_period", flags.Lookup("retention-purge-period"))
+	viper.BindPFlag("scheduled_messages_period", flags.Lookup("scheduled-messages-period"))
+	viper.BindPFlag("webhooks_allowed_networks", flags.Lookup("webhooks-allowed-networks"))
+	viper.BindPFlag("webhooks_period", flags.Lookup("webhooks-period"))
+	viper.BindPFlag("webhooks_retries", flags.Lookup("webhooks-retries"))
+	viper.BindPFlag("webhooks_timeout", flags.Lookup("webhooks-timeout"))
+	viper.BindPFlag("rate_limit_user", flags.Lookup("rate-limit-user"))
+	viper.BindPFlag("rate_limit_user_topic", flags.Lookup("rate-limit-user-topic"))
+	viper.BindPFlag("rate_limit_system_user", flags.Lookup("rate-limit-system-user"))
+	viper.BindPFlag("rate_limit_system_user_topic", flags.Lookup("rate-limit-system-user-topic"))
+	viper.BindPFlag("rate_limit_topic", flags.Lookup("rate-limit-topic"))
+}
+
+// initConfig reads flags values from environment variables, prefixed by TAT_
+func initConfig() {
+	viper.SetEnvPrefix("tat")
+	viper.AutomaticEnv()
 }
 
 func main() {
@@ -0,0 +1,350 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+const (
+	// AdapterGithub converts pull requests events of GitHub, header X-GitHub-Event
+	AdapterGithub = "github"
+	// AdapterGitlab converts merge requests and pipelines events of GitLab, header X-Gitlab-Event
+	AdapterGitlab = "gitlab"
+	// AdapterJenkins converts builds events of Jenkins Notification plugin
+	AdapterJenkins = "jenkins"
+	// AdapterAlertmanager converts alerts of Prometheus Alertmanager
+	AdapterAlertmanager = "alertmanager"
+)
+
+// Adapters are names of built-in adapters
+var Adapters = []string{AdapterGithub, AdapterGitlab, AdapterJenkins, AdapterAlertmanager}
+
+// AdapterLabel is a label added by an adapter
+type AdapterLabel struct {
+	Text  string
+	Color string
+}
+
+// AdapterEvent is a message converted from a payload by an adapter. Messages of
+// a same ExternalKey are a thread: first event creates message, next events
+// update its labels and reply to it with Text
+type AdapterEvent struct {
+	Kind         string // key of template, as pull_request.merged
+	ExternalKey  string
+	Text         string
+	Labels       []AdapterLabel
+	RemoveLabels []string
+	Tags         []string
+}
+
+// adapterStatus is status of a thread of an adapter, with its label
+type adapterStatus struct {
+	kind  string
+	label AdapterLabel
+}
+
+var (
+	labelOpen     = AdapterLabel{Text: "open", Color: "#14892c"}
+	labelMerged   = AdapterLabel{Text: "merged", Color: "#6f42c1"}
+	labelClosed   = AdapterLabel{Text: "closed", Color: "#d04437"}
+	labelRunning  = AdapterLabel{Text: "running", Color: "#4a6785"}
+	labelSuccess  = AdapterLabel{Text: "success", Color: "#14892c"}
+	labelFailed   = AdapterLabel{Text: "failed", Color: "#d04437"}
+	labelUnstable = AdapterLabel{Text: "unstable", Color: "#f6c342"}
+	labelCanceled = AdapterLabel{Text: "canceled", Color: "#cccccc"}
+	labelFiring   = AdapterLabel{Text: "firing", Color: "#d04437"}
+	labelResolved = AdapterLabel{Text: "resolved", Color: "#14892c"}
+)
+
+// statusLabels are labels of status of threads, exclusive
+var statusLabels = []AdapterLabel{labelOpen, labelMerged, labelClosed, labelRunning, labelSuccess,
+	labelFailed, labelUnstable, labelCanceled, labelFiring, labelResolved}
+
+// AdapterTemplates are default templates of text of messages, by kind.
+// Templates are executed on payload, or on an alert for Alertmanager
+var AdapterTemplates = map[string]string{
+	"pull_request.opened":    `PR #{{.number}} opened on {{.repository.full_name}} by {{.sender.login}}: {{.pull_request.title}} {{.pull_request.html_url}}`,
+	"pull_request.reopened":  `PR #{{.number}} reopened by {{.sender.login}}`,
+	"pull_request.merged":    `PR #{{.number}} merged by {{.sender.login}}`,
+	"pull_request.closed":    `PR #{{.number}} closed by {{.sender.login}}`,
+	"merge_request.opened":   `MR !{{.object_attributes.iid}} opened on {{.project.path_with_namespace}} by {{.user.username}}: {{.object_attributes.title}} {{.object_attributes.url}}`,
+	"merge_request.reopened": `MR !{{.object_attributes.iid}} reopened by {{.user.username}}`,
+	"merge_request.merged":   `MR !{{.object_attributes.iid}} merged by {{.user.username}}`,
+	"merge_request.closed":   `MR !{{.object_attributes.iid}} closed by {{.user.username}}`,
+	"pipeline.running":       `Pipeline #{{.object_attributes.id}} running on {{.project.path_with_namespace}} {{.object_attributes.ref}}`,
+	"pipeline.success":       `Pipeline #{{.object_attributes.id}} success`,
+	"pipeline.failed":        `Pipeline #{{.object_attributes.id}} failed`,
+	"pipeline.canceled":      `Pipeline #{{.object_attributes.id}} canceled`,
+	"build.started":          `Build {{.name}} #{{.build.number}} started {{.build.full_url}}`,
+	"build.success":          `Build {{.name}} #{{.build.number}} success`,
+	"build.failed":           `Build {{.name}} #{{.build.number}} failed`,
+	"build.unstable":         `Build {{.name}} #{{.build.number}} unstable`,
+	"build.canceled":         `Build {{.name}} #{{.build.number}} aborted`,
+	"alert.firing":           `{{.labels.alertname}} firing{{with .annotations.summary}}: {{.}}{{end}}{{with .annotations.description}} {{.}}{{end}}`,
+	"alert.resolved":         `{{.labels.alertname}} resolved`,
+}
+
+// IsValidAdapter returns true if adapter is a built-in adapter
+func IsValidAdapter(adapter string) bool {
+	return ArrayContains(Adapters, adapter)
+}
+
+// CheckAdapterTemplates returns an error if a template does not parse,
+// or if its kind is not a kind of events
+func CheckAdapterTemplates(templates map[string]string) error {
+	for kind, templ := range templates {
+		if _, ok := AdapterTemplates[kind]; !ok {
+			return fmt.Errorf("Invalid template %s, unknown kind of event", kind)
+		}
+		if _, err := template.New(kind).Parse(templ); err != nil {
+			return fmt.Errorf("Invalid template %s: %s", kind, err)
+		}
+	}
+	return nil
+}
+
+// ConvertPayload converts body of a webhook to events, with adapter. event is type of
+// event given in headers by GitHub and GitLab. templates override default templates by kind.
+// Events not handled by adapter are ignored, no event is returned
+func ConvertPayload(adapter, event string, body []byte, templates map[string]string) ([]AdapterEvent, error) {
+	// numbers are kept as written, big ids are not formatted as floats by templates
+	var payload map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, fmt.Errorf("Invalid JSON payload: %s", err)
+	}
+
+	var events []AdapterEvent
+	var err error
+	switch adapter {
+	case AdapterGithub:
+		events, err = convertGithub(event, payload)
+	case AdapterGitlab:
+		events, err = convertGitlab(event, payload)
+	case AdapterJenkins:
+		events, err = convertJenkins(payload)
+	case AdapterAlertmanager:
+		events, err = convertAlertmanager(payload)
+	default:
+		return nil, fmt.Errorf("Invalid adapter %s", adapter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range events {
+		data := payload
+		if adapter == AdapterAlertmanager {
+			data = payloadPath(payload, "alerts").([]interface{})[i].(map[string]interface{})
+		}
+		if events[i].Text, err = renderAdapterTemplate(events[i].Kind, data, templates); err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// renderAdapterTemplate executes template of kind on data
+func renderAdapterTemplate(kind string, data map[string]interface{}, templates map[string]string) (string, error) {
+	templ, ok := templates[kind]
+	if !ok {
+		templ = AdapterTemplates[kind]
+	}
+	t, err := template.New(kind).Parse(templ)
+	if err != nil {
+		return "", fmt.Errorf("Invalid template %s: %s", kind, err)
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("Error with template %s: %s", kind, err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// newAdapterEvent returns an event of kind, with status label. Other labels
+// of status are removed from thread
+func newAdapterEvent(externalKey string, status adapterStatus, tags ...string) AdapterEvent {
+	e := AdapterEvent{Kind: status.kind, ExternalKey: externalKey, Labels: []AdapterLabel{status.label}, Tags: tags}
+	for _, l := range statusLabels {
+		if l.Text != status.label.Text {
+			e.RemoveLabels = append(e.RemoveLabels, l.Text)
+		}
+	}
+	return e
+}
+
+// payloadPath returns value at path of payload, as pull_request.merged, nil if not found
+func payloadPath(payload map[string]interface{}, path string) interface{} {
+	var v interface{} = payload
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+// payloadString returns value at path of payload as a string
+func payloadString(payload map[string]interface{}, path string) string {
+	switch v := payloadPath(payload, path).(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return fmt.Sprintf("%t", v)
+	}
+	return ""
+}
+
+// convertGithub converts opened, reopened and closed pull requests
+func convertGithub(event string, payload map[string]interface{}) ([]AdapterEvent, error) {
+	if event != "pull_request" {
+		return nil, nil
+	}
+	repository := payloadString(payload, "repository.full_name")
+	number := payloadString(payload, "number")
+	if repository == "" || number == "" {
+		return nil, fmt.Errorf("Invalid pull_request payload, repository.full_name and number are expected")
+	}
+
+	var status adapterStatus
+	switch payloadString(payload, "action") {
+	case "opened":
+		status = adapterStatus{"pull_request.opened", labelOpen}
+	case "reopened":
+		status = adapterStatus{"pull_request.reopened", labelOpen}
+	case "closed":
+		status = adapterStatus{"pull_request.closed", labelClosed}
+		if payloadString(payload, "pull_request.merged") == "true" {
+			status = adapterStatus{"pull_request.merged", labelMerged}
+		}
+	default:
+		return nil, nil
+	}
+	key := "github:pr:" + repository + "#" + number
+	return []AdapterEvent{newAdapterEvent(key, status, "repo:"+repository)}, nil
+}
+
+// convertGitlab converts merge requests and pipelines
+func convertGitlab(event string, payload map[string]interface{}) ([]AdapterEvent, error) {
+	project := payloadString(payload, "project.path_with_namespace")
+	if project == "" {
+		return nil, fmt.Errorf("Invalid payload, project.path_with_namespace is expected")
+	}
+
+	var status adapterStatus
+	var key string
+	switch event {
+	case "Merge Request Hook":
+		switch payloadString(payload, "object_attributes.action") {
+		case "open":
+			status = adapterStatus{"merge_request.opened", labelOpen}
+		case "reopen":
+			status = adapterStatus{"merge_request.reopened", labelOpen}
+		case "merge":
+			status = adapterStatus{"merge_request.merged", labelMerged}
+		case "close":
+			status = adapterStatus{"merge_request.closed", labelClosed}
+		default:
+			return nil, nil
+		}
+		key = "gitlab:mr:" + project + "!" + payloadString(payload, "object_attributes.iid")
+	case "Pipeline Hook":
+		switch payloadString(payload, "object_attributes.status") {
+		case "running":
+			status = adapterStatus{"pipeline.running", labelRunning}
+		case "success":
+			status = adapterStatus{"pipeline.success", labelSuccess}
+		case "failed":
+			status = adapterStatus{"pipeline.failed", labelFailed}
+		case "canceled":
+			status = adapterStatus{"pipeline.canceled", labelCanceled}
+		default:
+			return nil, nil
+		}
+		key = "gitlab:pipeline:" + project + "#" + payloadString(payload, "object_attributes.id")
+	default:
+		return nil, nil
+	}
+	return []AdapterEvent{newAdapterEvent(key, status, "repo:"+project)}, nil
+}
+
+// convertJenkins converts started and completed builds of Notification plugin
+func convertJenkins(payload map[string]interface{}) ([]AdapterEvent, error) {
+	name := payloadString(payload, "name")
+	number := payloadString(payload, "build.number")
+	if name == "" || number == "" {
+		return nil, fmt.Errorf("Invalid payload, name and build.number are expected")
+	}
+
+	var status adapterStatus
+	switch payloadString(payload, "build.phase") {
+	case "STARTED":
+		status = adapterStatus{"build.started", labelRunning}
+	case "COMPLETED":
+		switch payloadString(payload, "build.status") {
+		case "SUCCESS":
+			status = adapterStatus{"build.success", labelSuccess}
+		case "UNSTABLE":
+			status = adapterStatus{"build.unstable", labelUnstable}
+		case "ABORTED":
+			status = adapterStatus{"build.canceled", labelCanceled}
+		default:
+			status = adapterStatus{"build.failed", labelFailed}
+		}
+	default:
+		return nil, nil
+	}
+	return []AdapterEvent{newAdapterEvent("jenkins:"+name+"#"+number, status, "job:"+name)}, nil
+}
+
+// convertAlertmanager converts each alert, firing or resolved. An alert is
+// identified by its fingerprint, or by its labels
+func convertAlertmanager(payload map[string]interface{}) ([]AdapterEvent, error) {
+	alerts, ok := payloadPath(payload, "alerts").([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Invalid payload, alerts are expected")
+	}
+
+	var events []AdapterEvent
+	for _, a := range alerts {
+		alert, ok := a.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Invalid payload, an alert is not an object")
+		}
+		status := adapterStatus{"alert.firing", labelFiring}
+		if payloadString(alert, "status") == "resolved" {
+			status = adapterStatus{"alert.resolved", labelResolved}
+		}
+
+		key := payloadString(alert, "fingerprint")
+		if key == "" {
+			key = alertLabelsKey(alert)
+		}
+		e := newAdapterEvent("alertmanager:"+key, status, "alertname:"+payloadString(alert, "labels.alertname"))
+		if severity := payloadString(alert, "labels.severity"); severity != "" && status.label == labelFiring {
+			e.Labels = append(e.Labels, AdapterLabel{Text: severity, Color: "#f79232"})
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// alertLabelsKey returns labels of alert, sorted, as name=value,name=value
+func alertLabelsKey(alert map[string]interface{}) string {
+	labels, _ := payloadPath(alert, "labels").(map[string]interface{})
+	var keys []string
+	for k, v := range labels {
+		keys = append(keys, fmt.Sprintf("%s=%v", k, v))
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
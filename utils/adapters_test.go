@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const githubPullRequest = `{"action": "%s", "number": 42,
+	"pull_request": {"title": "Fix purge", "html_url": "https://github.com/ovh/tat/pull/42", "merged": %s},
+	"repository": {"full_name": "ovh/tat"}, "sender": {"login": "userA"}}`
+
+func TestConvertGithub(t *testing.T) {
+	events, err := ConvertPayload(AdapterGithub, "pull_request", []byte(fmt.Sprintf(githubPullRequest, "opened", "false")), nil)
+	assert.Nil(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, "pull_request.opened", events[0].Kind)
+	assert.Equal(t, "github:pr:ovh/tat#42", events[0].ExternalKey)
+	assert.Equal(t, "PR #42 opened on ovh/tat by userA: Fix purge https://github.com/ovh/tat/pull/42", events[0].Text)
+	assert.Equal(t, "open", events[0].Labels[0].Text)
+	assert.Equal(t, []string{"repo:ovh/tat"}, events[0].Tags)
+
+	events, err = ConvertPayload(AdapterGithub, "pull_request", []byte(fmt.Sprintf(githubPullRequest, "closed", "true")), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "pull_request.merged", events[0].Kind)
+	assert.Equal(t, "github:pr:ovh/tat#42", events[0].ExternalKey, "merge should be on same thread")
+	assert.Equal(t, "merged", events[0].Labels[0].Text)
+	assert.Contains(t, events[0].RemoveLabels, "open")
+	assert.NotContains(t, events[0].RemoveLabels, "merged")
+
+	events, err = ConvertPayload(AdapterGithub, "pull_request", []byte(fmt.Sprintf(githubPullRequest, "synchronize", "false")), nil)
+	assert.Nil(t, err)
+	assert.Len(t, events, 0, "synchronize should be ignored")
+
+	events, err = ConvertPayload(AdapterGithub, "ping", []byte(`{"zen": "Keep it simple"}`), nil)
+	assert.Nil(t, err)
+	assert.Len(t, events, 0, "ping should be ignored")
+}
+
+func TestConvertJenkinsBigNumber(t *testing.T) {
+	body := `{"name": "tat-build", "build": {"number": 1234567, "phase": "COMPLETED", "status": "FAILURE"}}`
+	events, err := ConvertPayload(AdapterJenkins, "", []byte(body), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "build.failed", events[0].Kind)
+	assert.Equal(t, "jenkins:tat-build#1234567", events[0].ExternalKey)
+	assert.Equal(t, "Build tat-build #1234567 failed", events[0].Text)
+}
+
+func TestConvertAlertmanager(t *testing.T) {
+	body := `{"status": "firing", "alerts": [
+		{"status": "firing", "fingerprint": "abc", "labels": {"alertname": "DiskFull", "severity": "critical"}, "annotations": {"summary": "db1 is full"}},
+		{"status": "resolved", "labels": {"alertname": "HighLoad", "instance": "web1"}}]}`
+	templates := map[string]string{"alert.resolved": "{{.labels.alertname}} on {{.labels.instance}} is back to normal"}
+
+	events, err := ConvertPayload(AdapterAlertmanager, "", []byte(body), templates)
+	assert.Nil(t, err)
+	assert.Len(t, events, 2)
+
+	assert.Equal(t, "alert.firing", events[0].Kind)
+	assert.Equal(t, "alertmanager:abc", events[0].ExternalKey)
+	assert.Equal(t, "DiskFull firing: db1 is full", events[0].Text)
+	assert.Equal(t, "firing", events[0].Labels[0].Text)
+	assert.Equal(t, "critical", events[0].Labels[1].Text)
+
+	assert.Equal(t, "alert.resolved", events[1].Kind)
+	assert.Equal(t, "alertmanager:alertname=HighLoad,instance=web1", events[1].ExternalKey)
+	assert.Equal(t, "HighLoad on web1 is back to normal", events[1].Text, "template should be overridden")
+	assert.Contains(t, events[1].RemoveLabels, "firing")
+}
+
+func TestCheckAdapterTemplates(t *testing.T) {
+	assert.Nil(t, CheckAdapterTemplates(map[string]string{"alert.firing": "{{.labels.alertname}}"}))
+	assert.NotNil(t, CheckAdapterTemplates(map[string]string{"alert.unknown": "text"}))
+	assert.NotNil(t, CheckAdapterTemplates(map[string]string{"alert.firing": "{{.labels"}))
+}
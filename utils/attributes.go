@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+)
+
+// AttributePrefix is the prefix of query parameters filtering on attributes
+const AttributePrefix = "attr."
+
+// ParseAttributeFilter parses a query parameter filtering on an attribute, as
+// attr.key=value, attr.key>value, attr.key>=value, attr.key<value, attr.key<=value
+// or attr.key!=value. As url query is split on =, name is the part before first =
+// and value the part after it. Returns key, operator and value of filter, ok is
+// false if name is not a filter on attribute
+func ParseAttributeFilter(name, value string) (key, operator, val string, ok bool) {
+	if !strings.HasPrefix(name, AttributePrefix) {
+		return "", "", "", false
+	}
+	name = name[len(AttributePrefix):]
+
+	// attr.key>=value, attr.key<=value or attr.key!=value
+	for _, suffix := range []string{">", "<", "!"} {
+		if strings.HasSuffix(name, suffix) && len(name) > 1 {
+			return name[:len(name)-1], suffix + "=", value, true
+		}
+	}
+	// attr.key>value or attr.key<value, without =
+	for _, op := range []string{">", "<"} {
+		if idx := strings.Index(name, op); idx > 0 {
+			return name[:idx], op, name[idx+1:], true
+		}
+	}
+	if name == "" {
+		return "", "", "", false
+	}
+	return name, "=", value, true
+}
+
+// ParseAttributeValue returns value as a float64 if it is a number, a bool if
+// it is true or false, as a string otherwise
+func ParseAttributeValue(value string) interface{} {
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f
+	}
+	if b, err := strconv.ParseBool(value); err == nil && (value == "true" || value == "false") {
+		return b
+	}
+	return value
+}
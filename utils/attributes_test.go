@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAttributeFilter(t *testing.T) {
+	tests := []struct {
+		name, value         string
+		key, operator, want string
+	}{
+		{"attr.env", "prod", "env", "=", "prod"},
+		{"attr.latency>200", "", "latency", ">", "200"},
+		{"attr.latency<200", "", "latency", "<", "200"},
+		{"attr.latency>", "200", "latency", ">=", "200"},
+		{"attr.latency<", "200", "latency", "<=", "200"},
+		{"attr.env!", "prod", "env", "!=", "prod"},
+		{"attr.env", "", "env", "=", ""},
+	}
+	for _, test := range tests {
+		key, operator, val, ok := ParseAttributeFilter(test.name, test.value)
+		assert.True(t, ok, "should be true for %s", test.name)
+		assert.Equal(t, test.key, key, "should be same")
+		assert.Equal(t, test.operator, operator, "should be same")
+		assert.Equal(t, test.want, val, "should be same")
+	}
+}
+
+func TestParseAttributeFilterInvalid(t *testing.T) {
+	_, _, _, ok := ParseAttributeFilter("label", "prod")
+	assert.False(t, ok, "should be false")
+}
+
+func TestParseAttributeValue(t *testing.T) {
+	assert.Equal(t, float64(200), ParseAttributeValue("200"), "should be a number")
+	assert.Equal(t, true, ParseAttributeValue("true"), "should be a bool")
+	assert.Equal(t, "prod", ParseAttributeValue("prod"), "should be a string")
+}
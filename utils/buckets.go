@@ -0,0 +1,38 @@
+package utils
+
+// buckets of a week start on Monday 1970-01-05 00:00 UTC,
+// as Unix epoch is a Thursday
+const weekOffset = 4 * 24 * 3600
+
+// BucketSize returns size in seconds of buckets of period hour, day or week,
+// 0 if period is unknown
+func BucketSize(period string) int64 {
+	switch period {
+	case "hour":
+		return 3600
+	case "day":
+		return 24 * 3600
+	case "week":
+		return 7 * 24 * 3600
+	}
+	return 0
+}
+
+// BucketOffset returns offset from Unix epoch of buckets of size
+func BucketOffset(size int64) int64 {
+	if size == BucketSize("week") {
+		return weekOffset
+	}
+	return 0
+}
+
+// BucketStart returns start of bucket of size containing date, in UTC.
+// Date and start are Unix timestamps, buckets of a week start on Monday
+func BucketStart(date, size int64) int64 {
+	shifted := date - BucketOffset(size)
+	mod := shifted % size
+	if mod < 0 {
+		mod += size
+	}
+	return date - mod
+}
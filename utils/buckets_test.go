@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBucketStart(t *testing.T) {
+	// Wednesday 2015-10-14 13:45:30 UTC
+	date := time.Date(2015, 10, 14, 13, 45, 30, 0, time.UTC).Unix()
+
+	hour := time.Date(2015, 10, 14, 13, 0, 0, 0, time.UTC).Unix()
+	assert.Equal(t, hour, BucketStart(date, BucketSize("hour")), "should be start of hour")
+
+	day := time.Date(2015, 10, 14, 0, 0, 0, 0, time.UTC).Unix()
+	assert.Equal(t, day, BucketStart(date, BucketSize("day")), "should be start of day")
+
+	monday := time.Date(2015, 10, 12, 0, 0, 0, 0, time.UTC).Unix()
+	assert.Equal(t, monday, BucketStart(date, BucketSize("week")), "should be Monday")
+	assert.Equal(t, monday, BucketStart(monday, BucketSize("week")), "should be same Monday")
+}
+
+func TestBucketSizeUnknown(t *testing.T) {
+	assert.Equal(t, int64(0), BucketSize("month"), "should be 0")
+}
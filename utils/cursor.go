@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EncodeCursor returns an opaque cursor for a position in a list
+// sorted by date, then by id
+func EncodeCursor(date int64, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(date, 10) + sep + id))
+}
+
+// DecodeCursor returns date and id of a cursor built with EncodeCursor
+func DecodeCursor(cursor string) (int64, string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", fmt.Errorf("Invalid cursor %s", cursor)
+	}
+	tuple := strings.SplitN(string(b), sep, 2)
+	if len(tuple) != 2 || tuple[1] == "" {
+		return 0, "", fmt.Errorf("Invalid cursor %s", cursor)
+	}
+	date, err := strconv.ParseInt(tuple[0], 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("Invalid cursor %s", cursor)
+	}
+	return date, tuple[1], nil
+}
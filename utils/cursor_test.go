@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursor(t *testing.T) {
+	cursor := EncodeCursor(1445000000, "56212a8ad2b7d6e2ad000001")
+	date, id, err := DecodeCursor(cursor)
+	assert.Nil(t, err, "should be nil")
+	assert.Equal(t, int64(1445000000), date, "should be same")
+	assert.Equal(t, "56212a8ad2b7d6e2ad000001", id, "should be same")
+}
+
+func TestDecodeInvalidCursor(t *testing.T) {
+	_, _, err := DecodeCursor("not a cursor")
+	assert.NotNil(t, err, "should be not nil")
+	_, _, err = DecodeCursor(EncodeCursor(1445000000, ""))
+	assert.NotNil(t, err, "should be not nil")
+}
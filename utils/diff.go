@@ -0,0 +1,68 @@
+package utils
+
+import "strings"
+
+const (
+	// DiffEqual is a part of text present in both texts
+	DiffEqual = "="
+	// DiffInsert is a part of text present only in the new text
+	DiffInsert = "+"
+	// DiffDelete is a part of text present only in the old text
+	DiffDelete = "-"
+)
+
+// DiffPart is a part of a diff between two texts
+type DiffPart struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// DiffWords returns differences between oldText and newText, word by word.
+// Words are separated by white spaces, consecutive words with same type are
+// merged in one part
+func DiffWords(oldText, newText string) []DiffPart {
+	a := strings.Fields(oldText)
+	b := strings.Fields(newText)
+
+	// lcs[i][j] is the length of longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	parts := []DiffPart{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && a[i] == b[j] {
+			parts = appendDiffPart(parts, DiffEqual, a[i])
+			i++
+			j++
+		} else if j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]) {
+			parts = appendDiffPart(parts, DiffInsert, b[j])
+			j++
+		} else {
+			parts = appendDiffPart(parts, DiffDelete, a[i])
+			i++
+		}
+	}
+	return parts
+}
+
+func appendDiffPart(parts []DiffPart, typeDiff, word string) []DiffPart {
+	if len(parts) > 0 && parts[len(parts)-1].Type == typeDiff {
+		parts[len(parts)-1].Text += " " + word
+		return parts
+	}
+	return append(parts, DiffPart{Type: typeDiff, Text: word})
+}
@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffWords(t *testing.T) {
+	parts := DiffWords("build #123 is running", "build #123 is OK now")
+	expected := []DiffPart{
+		{Type: DiffEqual, Text: "build #123 is"},
+		{Type: DiffInsert, Text: "OK now"},
+		{Type: DiffDelete, Text: "running"},
+	}
+	assert.Equal(t, expected, parts, "should be same")
+}
+
+func TestDiffWordsSameText(t *testing.T) {
+	parts := DiffWords("a b c", "a  b c")
+	assert.Equal(t, []DiffPart{{Type: DiffEqual, Text: "a b c"}}, parts, "should be same")
+}
+
+func TestDiffWordsEmpty(t *testing.T) {
+	assert.Equal(t, []DiffPart{{Type: DiffInsert, Text: "a b"}}, DiffWords("", "a b"), "should be same")
+	assert.Equal(t, []DiffPart{}, DiffWords("", ""), "should be empty")
+}
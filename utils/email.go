@@ -72,7 +72,11 @@ func sendEmail(templ, subject, username, toUser, tokenVerify, text, cmd, device
 		log.Errorf("Error with Execute template:%s ", err.Error())
 		return err
 	}
+	return sendMail(subject, toUser, b)
+}
 
+// sendMail sends body to toUser, or displays it in console with no_smtp
+func sendMail(subject, toUser string, b bytes.Buffer) error {
 	if viper.GetBool("no_smtp") {
 		fmt.Println("##### NO SMTP DISPLAY MAIL IN CONSOLE ######")
 		fmt.Printf("Subject:%s\n", subject)
@@ -113,6 +117,7 @@ func sendEmail(templ, subject, username, toUser, tokenVerify, text, cmd, device
 	}
 
 	var c *smtp.Client
+	var err error
 	if viper.GetBool("smtp_tls") {
 
 		// Here is the key, you need to call tls.Dial instead of smtp.Dial
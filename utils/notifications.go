@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"text/template"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const templNotification = `Hello {{.Username}},
+{{range .Notifications}}
+{{.Author}} mentioned you on {{.Topic}}, {{.Date}}:
+{{.Text}}
+{{end}}
+To stop these emails, follow this link: {{.UnsubscribeURL}}
+
+Regards,
+--
+Tat Team
+`
+
+const templDigest = `Hello {{.Username}},
+
+You have {{.Count}} unread notifications on Tat.{{if gt .Count (len .Notifications)}} Here are the {{len .Notifications}} newest.{{end}}
+{{range .Notifications}}
+- {{.Date}}, {{.Author}} on {{.Topic}}:
+  {{.Text}}
+{{end}}
+To stop these emails, follow this link: {{.UnsubscribeURL}}
+
+Regards,
+--
+Tat Team
+`
+
+// NotificationEmail contains values given to templates of notifications emails
+type NotificationEmail struct {
+	Username       string
+	Count          int
+	Notifications  []NotificationEmailItem
+	UnsubscribeURL string
+}
+
+// NotificationEmailItem is a notification in an email
+type NotificationEmailItem struct {
+	Author    string
+	Topic     string
+	IDMessage string
+	Text      string
+	Date      string
+}
+
+// SendNotificationEmail sends an email of notifications to toUser
+func SendNotificationEmail(toUser, subject string, digest bool, data NotificationEmail) error {
+	b, err := renderNotificationEmail(digest, data)
+	if err != nil {
+		return err
+	}
+	return sendMail(subject, toUser, b)
+}
+
+// renderNotificationEmail executes template of notifications emails, read from file
+// notifications_email_template, or notifications_digest_template for a digest.
+// Default template is used if flag is empty
+func renderNotificationEmail(digest bool, data NotificationEmail) (bytes.Buffer, error) {
+	var b bytes.Buffer
+	templ, file := templNotification, viper.GetString("notifications_email_template")
+	if digest {
+		templ, file = templDigest, viper.GetString("notifications_digest_template")
+	}
+	if file != "" {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Errorf("Error while reading template %s: %s", file, err)
+			return b, err
+		}
+		templ = string(content)
+	}
+
+	t, err := template.New("Notification template").Parse(templ)
+	if err != nil {
+		log.Errorf("Error with parsing template:%s ", err.Error())
+		return b, err
+	}
+	if err := t.Execute(&b, data); err != nil {
+		log.Errorf("Error with Execute template:%s ", err.Error())
+		return b, err
+	}
+	return b, nil
+}
+
+// GetUnsubscribeURL returns url to disable emails of notifications of user
+func GetUnsubscribeURL(username, token string) string {
+	return fmt.Sprintf("%s://%s:%s%s/user/unsubscribe/%s/%s",
+		viper.GetString("exposed_scheme"), viper.GetString("exposed_host"), viper.GetString("exposed_port"), viper.GetString("exposed_path"), username, token)
+}
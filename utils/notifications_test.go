@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderNotificationEmail(t *testing.T) {
+	data := NotificationEmail{
+		Username:       "userA",
+		Count:          1,
+		Notifications:  []NotificationEmailItem{{Author: "userB", Topic: "/Internal/Alerts", Text: "@userA & @userC look", Date: "2015-10-14 13:45 UTC"}},
+		UnsubscribeURL: "http://localhost:8080/user/unsubscribe/userA/token",
+	}
+	b, err := renderNotificationEmail(false, data)
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(b.String(), "userB mentioned you on /Internal/Alerts"), "should contain author and topic")
+	assert.True(t, strings.Contains(b.String(), "@userA & @userC look"), "text should not be escaped")
+	assert.True(t, strings.Contains(b.String(), data.UnsubscribeURL), "should contain unsubscribe link")
+}
+
+func TestRenderNotificationEmailDigest(t *testing.T) {
+	data := NotificationEmail{
+		Username:      "userA",
+		Count:         3,
+		Notifications: []NotificationEmailItem{{Author: "userB", Topic: "/Internal/Alerts", Text: "first"}, {Author: "userC", Topic: "/Internal/Alerts", Text: "second"}},
+	}
+	b, err := renderNotificationEmail(true, data)
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(b.String(), "You have 3 unread notifications on Tat. Here are the 2 newest."), "should contain count")
+
+	data.Count = 2
+	b, err = renderNotificationEmail(true, data)
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(b.String(), "newest"), "all notifications are in email")
+}
@@ -0,0 +1,66 @@
+package utils
+
+import "math"
+
+// TokenBucket is a token bucket of a rate limit: Tokens available at
+// date Last, in Unix milliseconds. A bucket is refilled of rate tokens
+// by minute, up to rate tokens. A new bucket, with Last 0, is full
+type TokenBucket struct {
+	Tokens float64
+	Last   int64
+}
+
+// Take refills bucket at date now, in Unix milliseconds, and takes n tokens.
+// If n tokens are not available, bucket is not updated, and retryAfter is
+// the number of seconds before n tokens are available. More tokens than rate
+// are never available: they are rejected with a retryAfter of 0
+func (b *TokenBucket) Take(now int64, rate, n int) (bool, int64) {
+	if rate <= 0 || n <= 0 {
+		return true, 0
+	}
+	if n > rate {
+		return false, 0
+	}
+	tokens := b.refill(now, rate)
+	if tokens < float64(n) {
+		return false, retryAfter(tokens, rate, n)
+	}
+	b.Tokens = tokens - float64(n)
+	b.Last = now
+	return true, 0
+}
+
+// TakeUpTo refills bucket at date now, in Unix milliseconds, and takes up to n
+// tokens, as many as available. If some tokens are not taken, retryAfter is the
+// number of seconds before they are available, up to rate tokens
+func (b *TokenBucket) TakeUpTo(now int64, rate, n int) (int, int64) {
+	if rate <= 0 || n <= 0 {
+		return n, 0
+	}
+	tokens := b.refill(now, rate)
+	taken := int(math.Min(float64(n), math.Floor(tokens)))
+	if taken > 0 {
+		b.Tokens = tokens - float64(taken)
+		b.Last = now
+	}
+	if taken == n {
+		return taken, 0
+	}
+	return taken, retryAfter(tokens-float64(taken), rate, int(math.Min(float64(n-taken), float64(rate))))
+}
+
+// refill returns tokens of bucket at date now
+func (b *TokenBucket) refill(now int64, rate int) float64 {
+	if b.Last > 0 && now > b.Last {
+		return math.Min(float64(rate), b.Tokens+float64(now-b.Last)*float64(rate)/60000)
+	} else if b.Last > 0 {
+		return math.Min(float64(rate), b.Tokens)
+	}
+	return float64(rate)
+}
+
+// retryAfter returns the number of seconds before n tokens are available, from tokens
+func retryAfter(tokens float64, rate, n int) int64 {
+	ms := (float64(n) - tokens) * 60000 / float64(rate)
+	return int64(math.Max(1, math.Ceil(ms/1000)))
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucketTake(t *testing.T) {
+	b := TokenBucket{}
+	now := int64(1000000)
+
+	ok, _ := b.Take(now, 60, 60)
+	assert.True(t, ok, "a new bucket should be full")
+	assert.Equal(t, float64(0), b.Tokens)
+
+	ok, retryAfter := b.Take(now, 60, 1)
+	assert.False(t, ok, "an empty bucket should reject")
+	assert.Equal(t, int64(1), retryAfter, "one token is refilled by second")
+	assert.Equal(t, now, b.Last, "a rejected take should not update bucket")
+
+	ok, retryAfter = b.Take(now+500, 60, 10)
+	assert.False(t, ok)
+	assert.Equal(t, int64(10), retryAfter)
+
+	ok, _ = b.Take(now+10000, 60, 10)
+	assert.True(t, ok, "10 tokens should be refilled after 10 seconds")
+	assert.InDelta(t, 0, b.Tokens, 0.0001)
+
+	ok, _ = b.Take(now+3600000, 60, 60)
+	assert.True(t, ok, "bucket should be refilled up to rate")
+	assert.InDelta(t, 0, b.Tokens, 0.0001)
+}
+
+func TestTokenBucketLimits(t *testing.T) {
+	b := TokenBucket{}
+	ok, retryAfter := b.Take(1000, 10, 11)
+	assert.False(t, ok, "more tokens than rate should always be rejected")
+	assert.Equal(t, int64(0), retryAfter, "more tokens than rate are never available")
+
+	ok, _ = b.Take(1000, 0, 1000)
+	assert.True(t, ok, "a rate of 0 should not limit")
+}
+
+func TestTokenBucketTakeUpTo(t *testing.T) {
+	b := TokenBucket{}
+	now := int64(1000000)
+
+	taken, retryAfter := b.TakeUpTo(now, 60, 100)
+	assert.Equal(t, 60, taken, "a new bucket should give all its tokens")
+	assert.Equal(t, int64(40), retryAfter, "40 tokens are refilled after 40 seconds")
+	assert.Equal(t, now, b.Last)
+
+	taken, retryAfter = b.TakeUpTo(now+500, 60, 200)
+	assert.Equal(t, 0, taken, "an empty bucket should give no token")
+	assert.Equal(t, int64(60), retryAfter, "retryAfter should be up to a full refill")
+	assert.Equal(t, now, b.Last, "a take of no token should not update bucket")
+
+	taken, retryAfter = b.TakeUpTo(now+10000, 60, 5)
+	assert.Equal(t, 5, taken)
+	assert.Equal(t, int64(0), retryAfter)
+	assert.InDelta(t, 5, b.Tokens, 0.0001)
+}
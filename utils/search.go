@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// HighlightPre is inserted before a matched term in highlights
+	HighlightPre = "<em>"
+	// HighlightPost is inserted after a matched term in highlights
+	HighlightPost = "</em>"
+)
+
+const (
+	highlightContext     = 40
+	highlightMaxSnippets = 3
+)
+
+// suffixes removed from a search term to match its variants: running -> run
+var stemSuffixes = []string{"ing", "ed", "es", "ly", "s"}
+
+// SearchTerms returns terms of a full-text search, as used by MongoDB $text:
+// words, and phrases between double quotes. Negated terms (-word or -"a phrase")
+// are not returned
+func SearchTerms(search string) []string {
+	var terms []string
+	negate := false
+	for i := 0; i < len(search); i++ {
+		c := search[i]
+		switch {
+		case c == ' ' || c == '\t':
+			negate = false
+		case c == '-' && (i == 0 || search[i-1] == ' ' || search[i-1] == '\t'):
+			negate = true
+		case c == '"':
+			end := strings.Index(search[i+1:], "\"")
+			if end < 0 {
+				end = len(search) - i - 1
+			}
+			phrase := strings.TrimSpace(search[i+1 : i+1+end])
+			if phrase != "" && !negate {
+				terms = append(terms, phrase)
+			}
+			i += end + 1
+			negate = false
+		default:
+			end := strings.IndexAny(search[i:], " \t\"")
+			if end < 0 {
+				end = len(search) - i
+			}
+			if !negate {
+				terms = append(terms, search[i:i+end])
+			}
+			i += end - 1
+		}
+	}
+	return terms
+}
+
+func stem(word string) string {
+	for _, suffix := range stemSuffixes {
+		if len(word) > len(suffix)+2 && strings.HasSuffix(strings.ToLower(word), suffix) {
+			return word[:len(word)-len(suffix)]
+		}
+	}
+	return word
+}
+
+// Highlight returns snippets of text around given terms, matched terms are
+// surrounded by HighlightPre and HighlightPost. A word term matches words starting
+// with its stem, so that "running" matches "run" and "runs"
+func Highlight(text string, terms []string) []string {
+	if len(terms) == 0 {
+		return nil
+	}
+
+	var patterns []string
+	for _, term := range terms {
+		words := strings.Fields(term)
+		if len(words) == 0 {
+			continue
+		}
+		if len(words) > 1 {
+			for i := range words {
+				words[i] = regexp.QuoteMeta(words[i])
+			}
+			patterns = append(patterns, strings.Join(words, `\s+`))
+			continue
+		}
+		patterns = append(patterns, `\b`+regexp.QuoteMeta(stem(words[0]))+`\w*`)
+	}
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	re, err := regexp.Compile("(?i)(" + strings.Join(patterns, "|") + ")")
+	if err != nil {
+		return nil
+	}
+	matches := re.FindAllStringIndex(text, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	var snippets []string
+	for i := 0; i < len(matches) && len(snippets) < highlightMaxSnippets; {
+		start := runeStart(text, matches[i][0]-highlightContext)
+		end := runeStart(text, matches[i][1]+highlightContext)
+
+		snippet := ""
+		if start > 0 {
+			snippet = "..."
+		}
+		pos := start
+		// all matches in this snippet are highlighted
+		for ; i < len(matches) && matches[i][0] < end; i++ {
+			if matches[i][1] > end {
+				end = matches[i][1]
+			}
+			snippet += text[pos:matches[i][0]] + HighlightPre + text[matches[i][0]:matches[i][1]] + HighlightPost
+			pos = matches[i][1]
+		}
+		snippet += text[pos:end]
+		if end < len(text) {
+			snippet += "..."
+		}
+		snippets = append(snippets, snippet)
+	}
+	return snippets
+}
+
+// runeStart returns the position of first rune starting at pos or before,
+// bounded to text
+func runeStart(text string, pos int) int {
+	if pos <= 0 {
+		return 0
+	}
+	if pos >= len(text) {
+		return len(text)
+	}
+	for pos > 0 && !utf8.RuneStart(text[pos]) {
+		pos--
+	}
+	return pos
+}
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchTerms(t *testing.T) {
+	terms := SearchTerms(`deploy "build failed" -staging -"web front" prod`)
+	assert.Equal(t, []string{"deploy", "build failed", "prod"}, terms, "should be same")
+}
+
+func TestHighlight(t *testing.T) {
+	snippets := Highlight("Deploys of api are running", []string{"deploy", "run"})
+	assert.Equal(t, []string{"<em>Deploys</em> of api are <em>running</em>"}, snippets, "should be same")
+}
+
+func TestHighlightPhrase(t *testing.T) {
+	snippets := Highlight("the build  failed on web01", []string{"build failed"})
+	assert.Equal(t, []string{"the <em>build  failed</em> on web01"}, snippets, "should be same")
+}
+
+func TestHighlightSnippets(t *testing.T) {
+	text := "alert on host web01 " + string(make([]byte, 100)) + " alert on host web02"
+	snippets := Highlight(text, []string{"alert"})
+	assert.Equal(t, 2, len(snippets), "should have two snippets")
+	assert.Nil(t, Highlight("nothing here", []string{"alert"}), "should be nil")
+}
@@ -0,0 +1,19 @@
+package utils
+
+import "unicode/utf8"
+
+// TruncateRunes returns text truncated to maxLength characters, not bytes:
+// a multi-bytes character is never cut
+func TruncateRunes(text string, maxLength int) string {
+	if maxLength < 0 || utf8.RuneCountInString(text) <= maxLength {
+		return text
+	}
+	i := 0
+	for pos := range text {
+		if i == maxLength {
+			return text[:pos]
+		}
+		i++
+	}
+	return text
+}
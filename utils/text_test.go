@@ -0,0 +1,21 @@
+package utils
+
+import (
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncateRunes(t *testing.T) {
+	assert.Equal(t, "abc", TruncateRunes("abcdef", 3))
+	assert.Equal(t, "abc", TruncateRunes("abc", 3))
+	assert.Equal(t, "abc", TruncateRunes("abc", 10))
+	assert.Equal(t, "", TruncateRunes("abc", 0))
+
+	truncated := TruncateRunes("été à Noël", 5)
+	assert.Equal(t, "été à", truncated)
+	assert.True(t, utf8.ValidString(truncated), "a character should not be cut")
+
+	assert.Equal(t, "日本", TruncateRunes("日本語", 2))
+}
@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// WebhookSignatureHeader is header containing signature of body of a webhook
+const WebhookSignatureHeader = "X-Tat-Signature"
+
+// maxWebhookBackoff is max delay between two attempts of a webhook
+const maxWebhookBackoff = time.Hour
+
+// SignWebhook returns signature of body with secret: sha256= followed by
+// hexadecimal HMAC-SHA256 of body
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// CheckWebhookSignature returns true if signature is signature of body with secret
+func CheckWebhookSignature(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(signature), []byte(SignWebhook(secret, body)))
+}
+
+// PostWebhook posts JSON body to url, with headers and signature of body with secret.
+// Returns status code of response, and an error if status code is not 2xx
+func PostWebhook(url, secret string, body []byte, headers map[string]string, timeout time.Duration) (int, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Tat-Webhook")
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(secret, body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	client := &http.Client{Timeout: timeout, Transport: webhookTransport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	// body is read to reuse connection
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("Invalid status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// networks not reachable by webhooks, unless allowed by flag webhooks-allowed-networks:
+// loopback, private, shared, link-local and unspecified addresses
+var webhookDeniedNetworks = parseNetworks([]string{
+	"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10",
+	"169.254.0.0/16", "0.0.0.0/8", "::1/128", "fc00::/7", "fe80::/10", "::/128",
+})
+
+// webhookTransport checks addresses of webhooks on each connection,
+// redirects and DNS changes included
+var webhookTransport = &http.Transport{
+	Dial:                dialWebhook,
+	TLSHandshakeTimeout: 10 * time.Second,
+}
+
+func parseNetworks(cidrs []string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		if _, network, err := net.ParseCIDR(strings.TrimSpace(cidr)); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	return networks
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWebhookIP returns an error if ip is in a network denied to webhooks,
+// and not in networks of flag webhooks-allowed-networks
+func checkWebhookIP(ip net.IP) error {
+	allowed := parseNetworks(strings.Split(viper.GetString("webhooks_allowed_networks"), ","))
+	if containsIP(allowed, ip) {
+		return nil
+	}
+	if containsIP(webhookDeniedNetworks, ip) || ip.IsMulticast() {
+		return fmt.Errorf("address %s is not allowed for a webhook", ip)
+	}
+	return nil
+}
+
+// resolveWebhookHost returns addresses of host, an error if one of them is denied
+func resolveWebhookHost(host string) ([]net.IP, error) {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if err := checkWebhookIP(ip); err != nil {
+			return nil, err
+		}
+	}
+	return ips, nil
+}
+
+// dialWebhook connects to an address checked by resolveWebhookHost,
+// address resolved is dialed, not host, to prevent DNS rebinding
+func dialWebhook(network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := resolveWebhookHost(host)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = dialer.Dial(network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// CheckWebhookURL returns an error if rawurl is not an http or https url,
+// or if its host resolves to an address denied to webhooks
+func CheckWebhookURL(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Invalid url %s, an http or https url is expected", rawurl)
+	}
+	if _, err := resolveWebhookHost(u.Hostname()); err != nil {
+		return fmt.Errorf("Invalid url %s: %s", rawurl, err)
+	}
+	return nil
+}
+
+// WebhookBackoff returns delay before attempt of a webhook, attempt starting at 1:
+// 0 for first attempt, then 10s, 20s, 40s... with a max of one hour
+func WebhookBackoff(attempt int) time.Duration {
+	if attempt <= 1 {
+		return 0
+	}
+	d := 10 * time.Second
+	for i := 2; i < attempt && d < maxWebhookBackoff; i++ {
+		d *= 2
+	}
+	if d > maxWebhookBackoff {
+		return maxWebhookBackoff
+	}
+	return d
+}
+
+// GetIncomingHookURL returns url to post messages on an incoming webhook
+func GetIncomingHookURL(idHook, token string) string {
+	return fmt.Sprintf("%s://%s:%s%s/incoming/%s/%s",
+		viper.GetString("exposed_scheme"), viper.GetString("exposed_host"), viper.GetString("exposed_port"), viper.GetString("exposed_path"), idHook, token)
+}
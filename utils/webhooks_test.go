@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	// test servers listen on loopback
+	viper.Set("webhooks_allowed_networks", "127.0.0.0/8,::1/128")
+}
+
+func TestPostWebhook(t *testing.T) {
+	body := []byte(`{"event":"label"}`)
+	var received []byte
+	var signature, event string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = ioutil.ReadAll(r.Body)
+		signature = r.Header.Get(WebhookSignatureHeader)
+		event = r.Header.Get("X-Tat-Event")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	status, err := PostWebhook(ts.URL, "secret", body, map[string]string{"X-Tat-Event": "label"}, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, status)
+	assert.Equal(t, body, received)
+	assert.Equal(t, "label", event)
+	assert.True(t, CheckWebhookSignature("secret", received, signature), "signature should be valid")
+	assert.False(t, CheckWebhookSignature("other", received, signature), "signature should be invalid with another secret")
+}
+
+func TestPostWebhookError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	status, err := PostWebhook(ts.URL, "secret", []byte("{}"), nil, time.Second)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+
+	ts.Close()
+	status, err = PostWebhook(ts.URL, "secret", []byte("{}"), nil, time.Second)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, status)
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	assert.Equal(t, time.Duration(0), WebhookBackoff(1))
+	assert.Equal(t, 10*time.Second, WebhookBackoff(2))
+	assert.Equal(t, 20*time.Second, WebhookBackoff(3))
+	assert.Equal(t, 40*time.Second, WebhookBackoff(4))
+	assert.Equal(t, time.Hour, WebhookBackoff(20))
+}
+
+func TestCheckWebhookURL(t *testing.T) {
+	assert.NotNil(t, CheckWebhookURL("ftp://example.com"))
+	assert.NotNil(t, CheckWebhookURL("http://"))
+	assert.NotNil(t, CheckWebhookURL("http://169.254.169.254/latest/meta-data"), "link-local should be denied")
+	assert.NotNil(t, CheckWebhookURL("http://10.1.2.3:8080/hook"), "private should be denied")
+	assert.NotNil(t, CheckWebhookURL("https://[fe80::1]/hook"), "link-local should be denied")
+	assert.Nil(t, CheckWebhookURL("http://127.0.0.1:8080/hook"), "loopback is allowed by flag in tests")
+	assert.Nil(t, CheckWebhookURL("https://93.184.216.34/hook"))
+}
+
+func TestPostWebhookDenied(t *testing.T) {
+	viper.Set("webhooks_allowed_networks", "")
+	defer viper.Set("webhooks_allowed_networks", "127.0.0.0/8,::1/128")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	status, err := PostWebhook(ts.URL, "secret", []byte("{}"), nil, time.Second)
+	assert.NotNil(t, err, "loopback should be denied on dial")
+	assert.Equal(t, 0, status)
+}